@@ -0,0 +1,295 @@
+package arq
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Arq7Tree is a directory in an Arq 6 or 7 backup set. Unlike an Arq 5
+// ArqTree, the directory's own metadata is kept in the node referencing it.
+type Arq7Tree struct {
+	Version uint32
+	// Sorted by name.
+	Nodes []Arq7TreeNode
+}
+
+type Arq7TreeNode struct {
+	Name string
+	Node Arq7Node
+}
+
+// Arq7Node is a file or directory. It is decoded from JSON or a plist in a
+// BackupRecord, and from the binary format inside an Arq7Tree.
+type Arq7Node struct {
+	IsTree bool `json:"isTree" plist:"isTree"`
+	// Only set for trees.
+	TreeBlobLoc    *Arq7BlobLoc  `json:"treeBlobLoc" plist:"treeBlobLoc"`
+	ComputerOSType uint32        `json:"computerOSType" plist:"computerOSType"`
+	DataBlobLocs   []Arq7BlobLoc `json:"dataBlobLocs" plist:"dataBlobLocs"`
+	AclBlobLoc     *Arq7BlobLoc  `json:"aclBlobLoc" plist:"aclBlobLoc"`
+	XattrsBlobLocs []Arq7BlobLoc `json:"xattrsBlobLocs" plist:"xattrsBlobLocs"`
+	// The size of a file, or the total size of everything in a tree.
+	ItemSize            uint64 `json:"itemSize" plist:"itemSize"`
+	ContainedFilesCount uint64 `json:"containedFilesCount" plist:"containedFilesCount"`
+
+	ModificationTimeSec  int64 `json:"modificationTime_sec" plist:"modificationTime_sec"`
+	ModificationTimeNsec int64 `json:"modificationTime_nsec" plist:"modificationTime_nsec"`
+	ChangeTimeSec        int64 `json:"changeTime_sec" plist:"changeTime_sec"`
+	ChangeTimeNsec       int64 `json:"changeTime_nsec" plist:"changeTime_nsec"`
+	CreationTimeSec      int64 `json:"creationTime_sec" plist:"creationTime_sec"`
+	CreationTimeNsec     int64 `json:"creationTime_nsec" plist:"creationTime_nsec"`
+
+	UserName  string `json:"userName" plist:"userName"`
+	GroupName string `json:"groupName" plist:"groupName"`
+	Deleted   bool   `json:"deleted" plist:"deleted"`
+
+	MacStDev   int32  `json:"mac_st_dev" plist:"mac_st_dev"`
+	MacStIno   uint64 `json:"mac_st_ino" plist:"mac_st_ino"`
+	MacStMode  uint32 `json:"mac_st_mode" plist:"mac_st_mode"`
+	MacStNlink uint32 `json:"mac_st_nlink" plist:"mac_st_nlink"`
+	UserID     uint32 `json:"mac_st_uid" plist:"mac_st_uid"`
+	GroupID    uint32 `json:"mac_st_gid" plist:"mac_st_gid"`
+	MacStRdev  int32  `json:"mac_st_rdev" plist:"mac_st_rdev"`
+	MacStFlags int32  `json:"mac_st_flags" plist:"mac_st_flags"`
+
+	WinAttrs uint32 `json:"winAttrs" plist:"winAttrs"`
+	// Only present from Tree version 2.
+	WinReparseTag              uint32 `json:"reparseTag" plist:"reparseTag"`
+	WinReparsePointIsDirectory bool   `json:"reparsePointIsDirectory" plist:"reparsePointIsDirectory"`
+}
+
+// Arq7BlobLoc is where a blob is stored: either a whole object at
+// RelativePath, or Length bytes at Offset within the pack at RelativePath.
+type Arq7BlobLoc struct {
+	BlobIdentifier string `json:"blobIdentifier" plist:"blobIdentifier"`
+	IsPacked       bool   `json:"isPacked" plist:"isPacked"`
+	// Only present in trees from Tree version 3.
+	IsLargePack bool `json:"isLargePack" plist:"isLargePack"`
+	// Relative to the directory containing the backup set, starting with the
+	// backup set's UUID.
+	RelativePath         string          `json:"relativePath" plist:"relativePath"`
+	Offset               uint64          `json:"offset" plist:"offset"`
+	Length               uint64          `json:"length" plist:"length"`
+	StretchEncryptionKey bool            `json:"stretchEncryptionKey" plist:"stretchEncryptionKey"`
+	CompressionType      CompressionType `json:"compressionType" plist:"compressionType"`
+}
+
+// Mtime returns the node's modification time.
+func (n *Arq7Node) Mtime() time.Time {
+	return time.Unix(n.ModificationTimeSec, n.ModificationTimeNsec)
+}
+
+// FileMode returns the node's permissions and type.
+func (n *Arq7Node) FileMode() os.FileMode {
+	return unixToFileMode(int32(n.MacStMode))
+}
+
+func decodeFields(r io.Reader, vs ...interface{}) error {
+	for _, v := range vs {
+		if err := DecodeArq(r, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Arq7Tree) UnmarshalArq(r io.Reader) error {
+	var count uint64
+	if err := decodeFields(r, &t.Version, &count); err != nil {
+		return err
+	}
+	if t.Version < 1 || t.Version > 3 {
+		return fmt.Errorf("unsupported Arq7Tree version %d", t.Version)
+	}
+	if count > maxArq7TreeNodes {
+		return fmt.Errorf("tree has %d nodes: %w", count, ErrTooLong)
+	}
+	t.Nodes = make([]Arq7TreeNode, count)
+	for i := range t.Nodes {
+		if err := DecodeArq(r, &t.Nodes[i].Name); err != nil {
+			return err
+		}
+		if err := t.Nodes[i].Node.decode(r, t.Version); err != nil {
+			return fmt.Errorf("node '%s': %w", t.Nodes[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Refuse to allocate more nodes or blob locations than this, directories and
+// files are rarely anywhere near as large.
+const (
+	maxArq7TreeNodes = 1 << 20
+	maxArq7BlobLocs  = 1 << 24
+)
+
+func (n *Arq7Node) decode(r io.Reader, treeVersion uint32) error {
+	var hasTreeBlobLoc bool
+	if err := decodeFields(r, &n.IsTree, &hasTreeBlobLoc); err != nil {
+		return err
+	}
+	if hasTreeBlobLoc {
+		n.TreeBlobLoc = &Arq7BlobLoc{}
+		if err := n.TreeBlobLoc.decode(r, treeVersion); err != nil {
+			return err
+		}
+	}
+	if err := DecodeArq(r, &n.ComputerOSType); err != nil {
+		return err
+	}
+	var err error
+	if n.DataBlobLocs, err = decodeBlobLocs(r, treeVersion); err != nil {
+		return err
+	}
+	var hasAclBlobLoc bool
+	if err := DecodeArq(r, &hasAclBlobLoc); err != nil {
+		return err
+	}
+	if hasAclBlobLoc {
+		n.AclBlobLoc = &Arq7BlobLoc{}
+		if err := n.AclBlobLoc.decode(r, treeVersion); err != nil {
+			return err
+		}
+	}
+	if n.XattrsBlobLocs, err = decodeBlobLocs(r, treeVersion); err != nil {
+		return err
+	}
+	if err := decodeFields(r,
+		&n.ItemSize, &n.ContainedFilesCount,
+		&n.ModificationTimeSec, &n.ModificationTimeNsec,
+		&n.ChangeTimeSec, &n.ChangeTimeNsec,
+		&n.CreationTimeSec, &n.CreationTimeNsec,
+		&n.UserName, &n.GroupName, &n.Deleted,
+		&n.MacStDev, &n.MacStIno, &n.MacStMode, &n.MacStNlink,
+		&n.UserID, &n.GroupID, &n.MacStRdev, &n.MacStFlags,
+		&n.WinAttrs,
+	); err != nil {
+		return err
+	}
+	if treeVersion >= 2 {
+		return decodeFields(r, &n.WinReparseTag, &n.WinReparsePointIsDirectory)
+	}
+	return nil
+}
+
+func decodeBlobLocs(r io.Reader, treeVersion uint32) ([]Arq7BlobLoc, error) {
+	var count uint64
+	if err := DecodeArq(r, &count); err != nil {
+		return nil, err
+	}
+	if count > maxArq7BlobLocs {
+		return nil, fmt.Errorf("node has %d blobs: %w", count, ErrTooLong)
+	}
+	var locs []Arq7BlobLoc
+	for i := uint64(0); i < count; i++ {
+		var loc Arq7BlobLoc
+		if err := loc.decode(r, treeVersion); err != nil {
+			return nil, err
+		}
+		locs = append(locs, loc)
+	}
+	return locs, nil
+}
+
+func (l *Arq7BlobLoc) decode(r io.Reader, treeVersion uint32) error {
+	if err := decodeFields(r, &l.BlobIdentifier, &l.IsPacked); err != nil {
+		return err
+	}
+	if treeVersion >= 3 {
+		if err := DecodeArq(r, &l.IsLargePack); err != nil {
+			return err
+		}
+	}
+	return decodeFields(r, &l.RelativePath, &l.Offset, &l.Length, &l.StretchEncryptionKey, &l.CompressionType)
+}
+
+func encodeFields(w io.Writer, vs ...interface{}) error {
+	for _, v := range vs {
+		if err := EncodeArq(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalArq encodes the tree in the binary format of its Version.
+func (t *Arq7Tree) MarshalArq(w io.Writer) (int, error) {
+	cw := &countingWriter{w: w}
+	if err := encodeFields(cw, t.Version, uint64(len(t.Nodes))); err != nil {
+		return int(cw.n), err
+	}
+	for i := range t.Nodes {
+		if err := EncodeArq(cw, t.Nodes[i].Name); err != nil {
+			return int(cw.n), err
+		}
+		if err := t.Nodes[i].Node.encode(cw, t.Version); err != nil {
+			return int(cw.n), err
+		}
+	}
+	return int(cw.n), nil
+}
+
+func (n *Arq7Node) encode(w io.Writer, treeVersion uint32) error {
+	if err := encodeFields(w, n.IsTree, n.TreeBlobLoc != nil); err != nil {
+		return err
+	}
+	if n.TreeBlobLoc != nil {
+		if err := n.TreeBlobLoc.encode(w, treeVersion); err != nil {
+			return err
+		}
+	}
+	if err := encodeFields(w, n.ComputerOSType, uint64(len(n.DataBlobLocs))); err != nil {
+		return err
+	}
+	for i := range n.DataBlobLocs {
+		if err := n.DataBlobLocs[i].encode(w, treeVersion); err != nil {
+			return err
+		}
+	}
+	if err := EncodeArq(w, n.AclBlobLoc != nil); err != nil {
+		return err
+	}
+	if n.AclBlobLoc != nil {
+		if err := n.AclBlobLoc.encode(w, treeVersion); err != nil {
+			return err
+		}
+	}
+	if err := EncodeArq(w, uint64(len(n.XattrsBlobLocs))); err != nil {
+		return err
+	}
+	for i := range n.XattrsBlobLocs {
+		if err := n.XattrsBlobLocs[i].encode(w, treeVersion); err != nil {
+			return err
+		}
+	}
+	if err := encodeFields(w,
+		n.ItemSize, n.ContainedFilesCount,
+		n.ModificationTimeSec, n.ModificationTimeNsec,
+		n.ChangeTimeSec, n.ChangeTimeNsec,
+		n.CreationTimeSec, n.CreationTimeNsec,
+		n.UserName, n.GroupName, n.Deleted,
+		n.MacStDev, n.MacStIno, n.MacStMode, n.MacStNlink,
+		n.UserID, n.GroupID, n.MacStRdev, n.MacStFlags,
+		n.WinAttrs,
+	); err != nil {
+		return err
+	}
+	if treeVersion >= 2 {
+		return encodeFields(w, n.WinReparseTag, n.WinReparsePointIsDirectory)
+	}
+	return nil
+}
+
+func (l *Arq7BlobLoc) encode(w io.Writer, treeVersion uint32) error {
+	if err := encodeFields(w, l.BlobIdentifier, l.IsPacked); err != nil {
+		return err
+	}
+	if treeVersion >= 3 {
+		if err := EncodeArq(w, l.IsLargePack); err != nil {
+			return err
+		}
+	}
+	return encodeFields(w, l.RelativePath, l.Offset, l.Length, l.StretchEncryptionKey, l.CompressionType)
+}
@@ -1,11 +1,13 @@
 package arq
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strconv"
 	"time"
 )
 
@@ -88,6 +90,17 @@ func (sh *ShaHash) UnmarshalArq(input io.Reader) error {
 	return nil
 }
 
+// MarshalArq encodes the hash as a hex string, or null if it is zero.
+func (sh ShaHash) MarshalArq(w io.Writer) (int, error) {
+	var s string
+	if sh != (ShaHash{}) {
+		s = sh.String()
+	}
+	cw := &countingWriter{w: w}
+	err := EncodeArq(cw, s)
+	return int(cw.n), err
+}
+
 type ArqTree struct {
 	// 54 72 65 65 56 30 32 32             "TreeV022"
 	Header                [8]byte
@@ -211,6 +224,47 @@ func (o *ArqPackIndex) UnmarshalArq(input io.Reader) error {
 	return nil
 }
 
+// setFanout sets Fanout from Objects, which must be sorted by SHA1.
+func (o *ArqPackIndex) setFanout() {
+	o.Fanout = [256]uint32{}
+	for _, obj := range o.Objects {
+		for b := int(obj.SHA1[0]); b < len(o.Fanout); b++ {
+			o.Fanout[b]++
+		}
+	}
+}
+
+// MarshalArq encodes the pack index, setting Fanout from Objects and
+// calculating its SHA1 trailer. Objects must be sorted by SHA1, as readers
+// search them by it.
+func (o *ArqPackIndex) MarshalArq(w io.Writer) (int, error) {
+	for i := 1; i < len(o.Objects); i++ {
+		if bytes.Compare(o.Objects[i-1].SHA1[:], o.Objects[i].SHA1[:]) > 0 {
+			return 0, fmt.Errorf("ArqPackIndex objects aren't sorted, %x is before %x", o.Objects[i-1].SHA1, o.Objects[i].SHA1)
+		}
+	}
+	o.setFanout()
+	h := sha1.New()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(io.MultiWriter(cw, h))
+	for _, v := range []interface{}{o.Header, o.Version, o.Fanout} {
+		if err := EncodeArq(bw, v); err != nil {
+			return int(cw.n), err
+		}
+	}
+	for _, obj := range o.Objects {
+		if err := EncodeArq(bw, obj); err != nil {
+			return int(cw.n), err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return int(cw.n), err
+	}
+	copy(o.SHA1[:], h.Sum(nil))
+	_, err := cw.Write(o.SHA1[:])
+	return int(cw.n), err
+}
+
 type ArqPackIndexObject struct {
 	Offset    uint64
 	Length    uint64
@@ -268,8 +322,206 @@ func (p *ArqPack) UnmarshalArq(input io.Reader) error {
 	return nil
 }
 
+// MarshalArq encodes the pack, setting ObjectCount from Objects and
+// calculating its SHA1 trailer.
+func (p *ArqPack) MarshalArq(w io.Writer) (int, error) {
+	h := sha1.New()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(io.MultiWriter(cw, h))
+	p.ObjectCount = uint64(len(p.Objects))
+	for _, v := range []interface{}{p.Magic, p.Version, p.ObjectCount} {
+		if err := EncodeArq(bw, v); err != nil {
+			return int(cw.n), err
+		}
+	}
+	for _, obj := range p.Objects {
+		if err := EncodeArq(bw, obj); err != nil {
+			return int(cw.n), err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return int(cw.n), err
+	}
+	copy(p.SHA1[:], h.Sum(nil))
+	_, err := cw.Write(p.SHA1[:])
+	return int(cw.n), err
+}
+
 type ArqPackObject struct {
 	Mimetype string
 	Name     string
 	Data     []byte `arq:"len-uint64"`
 }
+
+// ArqCommit is a snapshot of a folder, pointing at the root ArqTree and any
+// parent commits.
+type ArqCommit struct {
+	// 43 6f 6d 6d 69 74 56 30 31 32       "CommitV012"
+	Header  [10]byte
+	Version int
+
+	Author  string
+	Comment string
+	Parents []ArqCommitParent
+
+	// TreeBlobKey only has its Hash and EncryptionKeyStretched fields set.
+	TreeBlobKey         ArqBlobKey
+	TreeCompressionType CompressionType
+	Location            string
+
+	// Only present in Commit version 7 or older, and never used by Arq.
+	MergeCommonAncestor                ShaHash
+	MergeCommonAncestorEncKeyStretched bool
+
+	CreationDate    time.Time
+	FailedFiles     []ArqFailedFile
+	HasMissingNodes bool
+	IsComplete      bool
+	ConfigPlistXml  []byte
+
+	HasBucketXattrs     bool
+	BucketXattrsBlobKey ArqBlobKey
+}
+
+type ArqCommitParent struct {
+	Hash                   ShaHash
+	EncryptionKeyStretched bool
+}
+
+type ArqFailedFile struct {
+	RelativePath string
+	ErrorMessage string
+}
+
+var arqCommitHeaderPrefix = []byte("CommitV")
+
+func (c *ArqCommit) UnmarshalArq(input io.Reader) error {
+	if err := DecodeArq(input, &c.Header); err != nil {
+		return err
+	}
+	if !bytes.Equal(c.Header[:len(arqCommitHeaderPrefix)], arqCommitHeaderPrefix) {
+		return fmt.Errorf("magic bytes '% x' are incorrect for ArqCommit", c.Header)
+	}
+	v, err := strconv.Atoi(string(c.Header[len(arqCommitHeaderPrefix):]))
+	if err != nil || v < 1 || v > 12 {
+		return fmt.Errorf("unsupported ArqCommit version '%s'", c.Header[len(arqCommitHeaderPrefix):])
+	}
+	c.Version = v
+
+	if err := DecodeArq(input, &c.Author); err != nil {
+		return err
+	}
+	if err := DecodeArq(input, &c.Comment); err != nil {
+		return err
+	}
+
+	var numParents uint64
+	if err := DecodeArq(input, &numParents); err != nil {
+		return err
+	}
+	if numParents > 4096 {
+		return ErrTooLong
+	}
+	c.Parents = make([]ArqCommitParent, numParents)
+	for i := range c.Parents {
+		if err := DecodeArq(input, &c.Parents[i].Hash); err != nil {
+			return err
+		}
+		if v >= 4 {
+			if err := DecodeArq(input, &c.Parents[i].EncryptionKeyStretched); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := DecodeArq(input, &c.TreeBlobKey.Hash); err != nil {
+		return err
+	}
+	if v >= 4 {
+		if err := DecodeArq(input, &c.TreeBlobKey.EncryptionKeyStretched); err != nil {
+			return err
+		}
+	}
+	switch {
+	case v >= 10:
+		if err := DecodeArq(input, &c.TreeCompressionType); err != nil {
+			return err
+		}
+	case v >= 8:
+		// Before CompressionType existed, trees were either gzipped or not.
+		var isCompressed bool
+		if err := DecodeArq(input, &isCompressed); err != nil {
+			return err
+		}
+		if isCompressed {
+			c.TreeCompressionType = GzipCompression
+		}
+	}
+
+	if err := DecodeArq(input, &c.Location); err != nil {
+		return err
+	}
+	if v <= 7 {
+		if err := DecodeArq(input, &c.MergeCommonAncestor); err != nil {
+			return err
+		}
+		if v >= 4 {
+			if err := DecodeArq(input, &c.MergeCommonAncestorEncKeyStretched); err != nil {
+				return err
+			}
+		}
+	}
+	if err := DecodeArq(input, &c.CreationDate); err != nil {
+		return err
+	}
+
+	if v >= 3 {
+		var numFailed uint64
+		if err := DecodeArq(input, &numFailed); err != nil {
+			return err
+		}
+		if numFailed > 4096 {
+			return ErrTooLong
+		}
+		c.FailedFiles = make([]ArqFailedFile, numFailed)
+		for i := range c.FailedFiles {
+			if err := DecodeArq(input, &c.FailedFiles[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if v >= 8 {
+		if err := DecodeArq(input, &c.HasMissingNodes); err != nil {
+			return err
+		}
+	}
+	if v >= 9 {
+		if err := DecodeArq(input, &c.IsComplete); err != nil {
+			return err
+		}
+	}
+	if v >= 5 {
+		var length uint64
+		if err := DecodeArq(input, &length); err != nil {
+			return err
+		}
+		if length > 1<<20 {
+			return ErrTooLong
+		}
+		c.ConfigPlistXml = make([]byte, length)
+		if _, err := io.ReadFull(input, c.ConfigPlistXml); err != nil {
+			return err
+		}
+	}
+	if v >= 12 {
+		if err := DecodeArq(input, &c.HasBucketXattrs); err != nil {
+			return err
+		}
+		if c.HasBucketXattrs {
+			if err := DecodeArq(input, &c.BucketXattrsBlobKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
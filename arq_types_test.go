@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/sholiday/arq"
 	"github.com/stretchr/testify/assert"
@@ -119,3 +120,39 @@ func TestDecodePack(t *testing.T) {
 	assert.Equal(t, "", p.Objects[1].Mimetype)
 	assert.Equal(t, "", p.Objects[1].Name)
 }
+
+func TestDecodeCommit(t *testing.T) {
+	by, err := ioutil.ReadFile("testdata/types/1.commit")
+	assert.Nil(t, err)
+
+	r := bytes.NewReader(by)
+
+	c := arq.ArqCommit{}
+	err = arq.DecodeArq(r, &c)
+	if !assert.Nil(t, err, c) {
+		return
+	}
+	assert.Equal(t, []byte("CommitV012"), c.Header[:])
+	assert.Equal(t, 12, c.Version)
+	assert.Equal(t, "sholiday", c.Author)
+	assert.Equal(t, "complete", c.Comment)
+	assert.Equal(t, 0, len(c.Parents))
+	assert.Equal(t, "5d2d2b62a1b11b2e5977c5ea65cb4708e5f41887", c.TreeBlobKey.Hash.String())
+	assert.True(t, c.TreeBlobKey.EncryptionKeyStretched)
+	assert.Equal(t, arq.Lz4Compression, c.TreeCompressionType)
+	assert.Equal(t, "file://narrator/Users/Shared/arq/testdata/t1/src", c.Location)
+	assert.Equal(t, int64(1620386070218), c.CreationDate.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, 0, len(c.FailedFiles))
+	assert.False(t, c.HasMissingNodes)
+	assert.True(t, c.IsComplete)
+	assert.Equal(t, 1007, len(c.ConfigPlistXml))
+	assert.Contains(t, string(c.ConfigPlistXml), "<key>BucketUUID</key>")
+	assert.False(t, c.HasBucketXattrs)
+	assert.Equal(t, 0, r.Len(), "commit was not fully consumed")
+
+	t.Run("BadHeader", func(t *testing.T) {
+		bad := append([]byte("CommitV099"), by[10:]...)
+		var c arq.ArqCommit
+		assert.NotNil(t, arq.DecodeArq(bytes.NewReader(bad), &c))
+	})
+}
@@ -0,0 +1,413 @@
+package arq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"howett.net/plist"
+)
+
+// Arq 6 and 7 store each backup plan's data as a backup set, in a directory
+// named for its UUID:
+//
+//   backupconfig.json         a BackupConfig
+//   backupfolders.json        where objects are stored, by storage class
+//   backupplan.json           a BackupPlan
+//   encryptedkeyset.dat       the keys, if the backup set is encrypted
+//   backupfolders/<uuid>/backupfolder.json
+//   backupfolders/<uuid>/backuprecords/<5 digits>/<7 digits>.backuprecord
+//   blobpacks/, largeblobpacks/, treepacks/, standardobjects/
+//
+// Unlike an Arq 5 computer, blobs are found by their location, which is
+// recorded along with the hash in the tree or backup record referencing them.
+
+var ErrBackupSetNotOpen = errors.New("backup set has not been opened")
+
+// BackupSet is an Arq 6 or 7 backup set, the counterpart of an Arq 5
+// Computer.
+type BackupSet struct {
+	Uuid   string
+	Config BackupConfig
+
+	opened bool
+	base   string
+	fs     fs.Fs
+	enc    *encryptionV3
+}
+
+// BackupConfig is the unencrypted `backupconfig.json` of a backup set.
+type BackupConfig struct {
+	BackupName                 string   `json:"backupName" plist:"backupName"`
+	ComputerName               string   `json:"computerName" plist:"computerName"`
+	ComputerSerial             string   `json:"computerSerial" plist:"computerSerial"`
+	IsEncrypted                bool     `json:"isEncrypted" plist:"isEncrypted"`
+	IsWORM                     bool     `json:"isWORM" plist:"isWORM"`
+	ContainsGlacierArchives    bool     `json:"containsGlacierArchives" plist:"containsGlacierArchives"`
+	BlobIdentifierType         int      `json:"blobIdentifierType" plist:"blobIdentifierType"`
+	BlobStorageClass           string   `json:"blobStorageClass" plist:"blobStorageClass"`
+	MaxPackedItemLength        int64    `json:"maxPackedItemLength" plist:"maxPackedItemLength"`
+	ChunkerVersion             int      `json:"chunkerVersion" plist:"chunkerVersion"`
+	AdditionalUnpackedBlobDirs []string `json:"additionalUnpackedBlobDirs" plist:"additionalUnpackedBlobDirs"`
+}
+
+// BackupPlan is the `backupplan.json` of a backup set, the settings it was
+// created with. Only the most useful fields are decoded.
+type BackupPlan struct {
+	PlanUUID    string `json:"planUUID" plist:"planUUID"`
+	Name        string `json:"name" plist:"name"`
+	Version     int    `json:"version" plist:"version"`
+	Active      bool   `json:"active" plist:"active"`
+	IsEncrypted bool   `json:"isEncrypted" plist:"isEncrypted"`
+	// The plan for each backup folder, by its UUID. Only decoded from JSON.
+	BackupFolderPlansByUUID map[string]json.RawMessage `json:"backupFolderPlansByUUID" plist:"-"`
+}
+
+// BackupFolder is a folder backed up to a backup set, the counterpart of an
+// Arq 5 FolderInfo.
+type BackupFolder struct {
+	Uuid              string `json:"uuid" plist:"uuid"`
+	Name              string `json:"name" plist:"name"`
+	LocalPath         string `json:"localPath" plist:"localPath"`
+	LocalMountPoint   string `json:"localMountPoint" plist:"localMountPoint"`
+	DiskIdentifier    string `json:"diskIdentifier" plist:"diskIdentifier"`
+	StorageClass      string `json:"storageClass" plist:"storageClass"`
+	MigratedFromArq5  bool   `json:"migratedFromArq5" plist:"migratedFromArq5"`
+	MigratedFromArq60 bool   `json:"migratedFromArq60" plist:"migratedFromArq60"`
+
+	set *BackupSet
+}
+
+// BackupRecord is a snapshot of a backup folder, the counterpart of an Arq 5
+// ArqCommit. Its Node is the root of the folder.
+type BackupRecord struct {
+	Version            int      `json:"version" plist:"version"`
+	ArqVersion         string   `json:"arqVersion" plist:"arqVersion"`
+	BackupFolderUUID   string   `json:"backupFolderUUID" plist:"backupFolderUUID"`
+	BackupPlanUUID     string   `json:"backupPlanUUID" plist:"backupPlanUUID"`
+	CreationDate       float64  `json:"creationDate" plist:"creationDate"`
+	IsComplete         bool     `json:"isComplete" plist:"isComplete"`
+	ErrorCount         int      `json:"errorCount" plist:"errorCount"`
+	Archived           bool     `json:"archived" plist:"archived"`
+	CopiedFromCommit   bool     `json:"copiedFromCommit" plist:"copiedFromCommit"`
+	CopiedFromSnapshot bool     `json:"copiedFromSnapshot" plist:"copiedFromSnapshot"`
+	LocalPath          string   `json:"localPath" plist:"localPath"`
+	LocalMountPoint    string   `json:"localMountPoint" plist:"localMountPoint"`
+	VolumeName         string   `json:"volumeName" plist:"volumeName"`
+	DiskIdentifier     string   `json:"diskIdentifier" plist:"diskIdentifier"`
+	StorageClass       string   `json:"storageClass" plist:"storageClass"`
+	RelativePath       string   `json:"relativePath" plist:"relativePath"`
+	Node               Arq7Node `json:"node" plist:"node"`
+}
+
+// Created returns when the backup record was created.
+func (r *BackupRecord) Created() time.Time {
+	sec := int64(r.CreationDate)
+	return time.Unix(sec, int64((r.CreationDate-float64(sec))*1e9))
+}
+
+// ListBackupSets returns the Arq 6 and 7 backup sets under base, skipping
+// anything else such as Arq 5 computers.
+func ListBackupSets(ctx context.Context, f fs.Fs, base string) ([]BackupSet, error) {
+	entries, err := f.List(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	var sets []BackupSet
+	for _, entry := range entries {
+		d, ok := entry.(fs.Directory)
+		if !ok || !uuidRegex.MatchString(path.Base(d.Remote())) {
+			continue
+		}
+		bs := NewBackupSet(f, d.Remote())
+		if err := bs.readConfig(ctx); errors.Is(err, fs.ErrorObjectNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sets = append(sets, *bs)
+	}
+	return sets, nil
+}
+
+// NewBackupSet returns the backup set in the directory base. Its Config is
+// only read by Open.
+func NewBackupSet(f fs.Fs, base string) *BackupSet {
+	return &BackupSet{
+		Uuid: path.Base(base),
+		base: base,
+		fs:   f,
+	}
+}
+
+func (bs *BackupSet) readConfig(ctx context.Context) error {
+	o, err := bs.NewObject(ctx, "backupconfig.json")
+	if err != nil {
+		return err
+	}
+	by, err := readObject(ctx, o)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(by, &bs.Config)
+}
+
+// Open reads the backup set's configuration and, if it is encrypted, unlocks
+// its keys with passphrase.
+func (bs *BackupSet) Open(ctx context.Context, passphrase string) error {
+	if err := bs.readConfig(ctx); err != nil {
+		return err
+	}
+	if bs.Config.IsEncrypted {
+		o, err := bs.NewObject(ctx, "encryptedkeyset.dat")
+		if err != nil {
+			return err
+		}
+		rc, err := o.Open(ctx)
+		if err != nil {
+			return err
+		}
+		if bs.enc, err = UnlockKeySet(ctx, rc, passphrase); err != nil {
+			return err
+		}
+	}
+	bs.opened = true
+	return nil
+}
+
+func (bs *BackupSet) NewObject(ctx context.Context, p string) (fs.Object, error) {
+	return bs.fs.NewObject(ctx, path.Join(bs.base, p))
+}
+
+func (bs *BackupSet) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	return bs.fs.List(ctx, path.Join(bs.base, dir))
+}
+
+func readObject(ctx context.Context, o fs.Object) ([]byte, error) {
+	rc, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// decodeFile decodes one of the JSON or plist files of a backup set, which
+// depending on the version of Arq may be encrypted and LZ4 compressed, into
+// out.
+func (bs *BackupSet) decodeFile(ctx context.Context, p string, out interface{}) error {
+	if !bs.opened {
+		return ErrBackupSetNotOpen
+	}
+	o, err := bs.NewObject(ctx, p)
+	if err != nil {
+		return err
+	}
+	by, err := readObject(ctx, o)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(by, []byte("ARQO")) {
+		if bs.enc == nil {
+			return fmt.Errorf("%s is encrypted, but the backup set isn't", p)
+		}
+		if by, err = io.ReadAll(NewEObjectReader(bytes.NewReader(by), bs.enc)); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	trimmed := bytes.TrimLeft(by, " \t\r\n")
+	if !isJSON(trimmed) && !isPlist(trimmed) {
+		lr, err := newLz4Reader(bytes.NewReader(by))
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if by, err = io.ReadAll(lr); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		trimmed = bytes.TrimLeft(by, " \t\r\n")
+	}
+	if isPlist(trimmed) {
+		_, err = plist.Unmarshal(trimmed, out)
+	} else {
+		err = json.Unmarshal(by, out)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+// isJSON and isPlist check the start of a file, after any leading whitespace.
+func isJSON(by []byte) bool {
+	return bytes.HasPrefix(by, []byte("{"))
+}
+
+func isPlist(by []byte) bool {
+	return bytes.HasPrefix(by, []byte("bplist")) || bytes.HasPrefix(by, []byte("<?xml"))
+}
+
+// Plan reads the backup plan the backup set was created with.
+func (bs *BackupSet) Plan(ctx context.Context) (*BackupPlan, error) {
+	var plan BackupPlan
+	if err := bs.decodeFile(ctx, "backupplan.json", &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// ListBackupFolders returns the folders backed up to the backup set.
+func (bs *BackupSet) ListBackupFolders(ctx context.Context) ([]BackupFolder, error) {
+	entries, err := bs.List(ctx, "backupfolders")
+	if err != nil {
+		return nil, err
+	}
+	folders := make([]BackupFolder, 0, len(entries))
+	for _, entry := range entries {
+		d, ok := entry.(fs.Directory)
+		if !ok || !uuidRegex.MatchString(path.Base(d.Remote())) {
+			continue
+		}
+		var bf BackupFolder
+		if err := bs.decodeFile(ctx, path.Join("backupfolders", path.Base(d.Remote()), "backupfolder.json"), &bf); err != nil {
+			return nil, err
+		}
+		bf.set = bs
+		folders = append(folders, bf)
+	}
+	return folders, nil
+}
+
+// BackupRecordListEntry is a backup record of a folder. Its Name is the time
+// it was created, in seconds since the Unix epoch.
+type BackupRecordListEntry struct {
+	Name int64
+	o    fs.Object
+}
+
+var backupRecordRegex = regexp.MustCompile(`^[0-9]+\.backuprecord$`)
+
+// Backup records are stored as `<5 digits>/<7 digits>.backuprecord`, the
+// digits of their Name split so that no directory gets too large.
+const backupRecordSplit = 10000000
+
+func (bf *BackupFolder) recordsDir() string {
+	return path.Join("backupfolders", bf.Uuid, "backuprecords")
+}
+
+// ListBackupRecords returns the backup records of the folder, in reverse
+// chronological order (the most recent is first).
+func (bf *BackupFolder) ListBackupRecords(ctx context.Context) ([]BackupRecordListEntry, error) {
+	dirs, err := bf.set.List(ctx, bf.recordsDir())
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []BackupRecordListEntry
+	for _, dir := range dirs {
+		if _, ok := dir.(fs.Directory); !ok {
+			continue
+		}
+		high, err := strconv.ParseInt(path.Base(dir.Remote()), 10, 64)
+		if err != nil {
+			continue
+		}
+		entries, err := bf.set.fs.List(ctx, dir.Remote())
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			fName := path.Base(entry.Remote())
+			if !ok || !backupRecordRegex.MatchString(fName) {
+				continue
+			}
+			low, err := strconv.ParseInt(strings.TrimSuffix(fName, ".backuprecord"), 10, 64)
+			if err != nil {
+				continue
+			}
+			records = append(records, BackupRecordListEntry{
+				Name: high*backupRecordSplit + low,
+				o:    o,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name > records[j].Name
+	})
+	return records, nil
+}
+
+// BackupRecord loads the backup record with the given name.
+func (bf *BackupFolder) BackupRecord(ctx context.Context, name int64) (*BackupRecord, error) {
+	p := path.Join(bf.recordsDir(), fmt.Sprintf("%05d", name/backupRecordSplit), fmt.Sprintf("%07d.backuprecord", name%backupRecordSplit))
+	var r BackupRecord
+	if err := bf.set.decodeFile(ctx, p, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// BackupSet returns the backup set the folder belongs to.
+func (bf *BackupFolder) BackupSet() *BackupSet {
+	return bf.set
+}
+
+// ReadBlob returns the decrypted and decompressed contents of the blob at
+// loc.
+func (bs *BackupSet) ReadBlob(ctx context.Context, loc Arq7BlobLoc) (io.ReadCloser, error) {
+	if !bs.opened {
+		return nil, ErrBackupSetNotOpen
+	}
+	// Locations are relative to the directory containing the backup set.
+	o, err := bs.fs.NewObject(ctx, path.Join(path.Dir(bs.base), strings.TrimPrefix(loc.RelativePath, "/")))
+	if err != nil {
+		return nil, fmt.Errorf("blob %s: %w", loc.BlobIdentifier, err)
+	}
+	var options []fs.OpenOption
+	if loc.IsPacked {
+		if loc.Length == 0 {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		options = append(options, &fs.RangeOption{Start: int64(loc.Offset), End: int64(loc.Offset+loc.Length) - 1})
+	}
+	rc, err := o.Open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = rc
+	if bs.enc != nil {
+		r = NewEObjectReader(r, bs.enc)
+	}
+	dr, err := NewDecompressingReader(r, loc.CompressionType)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("blob %s: %w", loc.BlobIdentifier, err)
+	}
+	return &objectReadCloser{Reader: dr, Closer: rc}, nil
+}
+
+// Tree loads the tree at loc.
+func (bs *BackupSet) Tree(ctx context.Context, loc Arq7BlobLoc) (*Arq7Tree, error) {
+	rc, err := bs.ReadBlob(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var t Arq7Tree
+	if err := DecodeArq(rc, &t); err != nil {
+		return nil, fmt.Errorf("tree %s: %w", loc.BlobIdentifier, err)
+	}
+	return &t, nil
+}
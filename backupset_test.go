@@ -0,0 +1,406 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	arq7SetUuid    = "2C8B8D4E-6A63-4C59-9D6F-1A0D2E9F3B71"
+	arq7FolderUuid = "F1F4F1B5-3C2D-4E5F-8A9B-0C1D2E3F4A5B"
+)
+
+// lz4Frame compresses data the way Arq does, as a big endian length followed
+// by a single LZ4 block.
+func lz4Frame(t *testing.T, data []byte) []byte {
+	buf := make([]byte, 4+lz4.CompressBlockBound(len(data)))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	n, err := lz4.CompressBlock(data, buf[4:], nil)
+	assert.Nil(t, err)
+	assert.NotZero(t, n)
+	return buf[:4+n]
+}
+
+func writeFile(t *testing.T, p string, data []byte) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.Nil(t, ioutil.WriteFile(p, data, 0644))
+}
+
+func writeJSON(t *testing.T, p string, v interface{}) []byte {
+	by, err := json.Marshal(v)
+	assert.Nil(t, err)
+	writeFile(t, p, by)
+	return by
+}
+
+// writeArq7Set writes an Arq 7 backup set with a single folder into dir,
+// encrypted if passphrase isn't empty. Its latest backup record contains
+// `hello.txt`, split into two blobs in the same pack.
+func writeArq7Set(t *testing.T, dir, passphrase string) {
+	set := filepath.Join(dir, arq7SetUuid)
+	encrypt := func(data []byte) []byte { return data }
+	if passphrase != "" {
+		by := keySet(t, passphrase)
+		writeFile(t, filepath.Join(set, "encryptedkeyset.dat"), by)
+		enc, err := arq.UnlockKeySet(context.Background(), io.NopCloser(bytes.NewReader(by)), passphrase)
+		if !assert.Nil(t, err) {
+			return
+		}
+		encrypt = func(data []byte) []byte {
+			buf := new(bytes.Buffer)
+			w, err := arq.NewEObjectWriter(buf, enc)
+			assert.Nil(t, err)
+			w.Write(data)
+			assert.Nil(t, w.Close())
+			return buf.Bytes()
+		}
+	}
+	writeEncryptedJSON := func(p string, v interface{}) {
+		by, err := json.Marshal(v)
+		assert.Nil(t, err)
+		writeFile(t, p, encrypt(by))
+	}
+
+	writeJSON(t, filepath.Join(set, "backupconfig.json"), arq.BackupConfig{
+		BackupName:   "Back up to somewhere",
+		ComputerName: "narrator",
+		IsEncrypted:  passphrase != "",
+	})
+	writeEncryptedJSON(filepath.Join(set, "backupplan.json"), arq.BackupPlan{
+		PlanUUID: "0A1B2C3D-0A1B-0A1B-0A1B-0A1B2C3D4E5F",
+		Name:     "Back up to somewhere",
+		Version:  2,
+	})
+	writeEncryptedJSON(filepath.Join(set, "backupfolders", arq7FolderUuid, "backupfolder.json"), arq.BackupFolder{
+		Uuid:      arq7FolderUuid,
+		Name:      "src",
+		LocalPath: "/Users/sholiday/src",
+	})
+
+	blobPack := "/" + arq7SetUuid + "/blobpacks/AB/CDEF0123.pack"
+	blob1, blob2 := encrypt([]byte("hello, ")), encrypt([]byte("world\n"))
+	writeFile(t, filepath.Join(dir, blobPack), append(append([]byte{}, blob1...), blob2...))
+	file := arq.Arq7Node{
+		ComputerOSType: 1,
+		DataBlobLocs: []arq.Arq7BlobLoc{
+			{BlobIdentifier: "aa", IsPacked: true, RelativePath: blobPack, Offset: 0, Length: uint64(len(blob1))},
+			{BlobIdentifier: "bb", IsPacked: true, RelativePath: blobPack, Offset: uint64(len(blob1)), Length: uint64(len(blob2))},
+		},
+		ItemSize:            13,
+		ModificationTimeSec: 1607634900,
+		UserName:            "sholiday",
+		MacStMode:           0100644,
+		UserID:              501,
+	}
+	tree := &arq.Arq7Tree{
+		Version: 3,
+		Nodes:   []arq.Arq7TreeNode{{Name: "hello.txt", Node: file}},
+	}
+	var treeBuf bytes.Buffer
+	_, err := tree.MarshalArq(&treeBuf)
+	assert.Nil(t, err)
+	treePack := "/" + arq7SetUuid + "/treepacks/12/3456789A.pack"
+	treeBlob := encrypt(lz4Frame(t, treeBuf.Bytes()))
+	writeFile(t, filepath.Join(dir, treePack), append([]byte("padding"), treeBlob...))
+
+	records := filepath.Join(set, "backupfolders", arq7FolderUuid, "backuprecords")
+	// Written by hand, in the shape of a record from Arq 7, rather than by
+	// marshalling a BackupRecord, so that the keys are checked.
+	latest := fmt.Sprintf(`{
+  "archived" : false,
+  "arqVersion" : "7.4.1",
+  "backupFolderUUID" : "%s",
+  "backupPlanUUID" : "0A1B2C3D-0A1B-0A1B-0A1B-0A1B2C3D4E5F",
+  "backupRecordErrors" : [],
+  "copiedFromCommit" : false,
+  "copiedFromSnapshot" : false,
+  "creationDate" : 1607634962,
+  "diskIdentifier" : "ROOT",
+  "errorCount" : 0,
+  "isComplete" : true,
+  "localMountPoint" : "/",
+  "localPath" : "/Users/sholiday/src",
+  "node" : {
+    "aclBlobLoc" : null,
+    "changeTime_nsec" : 250000000,
+    "changeTime_sec" : 1607634901,
+    "computerOSType" : 1,
+    "containedFilesCount" : 1,
+    "creationTime_nsec" : 0,
+    "creationTime_sec" : 1607634800,
+    "dataBlobLocs" : [],
+    "deleted" : false,
+    "groupName" : "staff",
+    "isTree" : true,
+    "itemSize" : 13,
+    "mac_st_dev" : 16777220,
+    "mac_st_flags" : 0,
+    "mac_st_gid" : 20,
+    "mac_st_ino" : 8675309,
+    "mac_st_mode" : 16877,
+    "mac_st_nlink" : 3,
+    "mac_st_rdev" : 0,
+    "mac_st_uid" : 501,
+    "modificationTime_nsec" : 500000000,
+    "modificationTime_sec" : 1607634900,
+    "treeBlobLoc" : {
+      "blobIdentifier" : "cc",
+      "compressionType" : 2,
+      "isLargePack" : false,
+      "isPacked" : true,
+      "length" : %d,
+      "offset" : 7,
+      "relativePath" : "%s",
+      "stretchEncryptionKey" : true
+    },
+    "userName" : "sholiday",
+    "winAttrs" : 0,
+    "xattrsBlobLocs" : []
+  },
+  "relativePath" : "/%s/backupfolders/%s/backuprecords/00160/7634962.backuprecord",
+  "storageClass" : "STANDARD",
+  "version" : 100,
+  "volumeName" : "Macintosh HD"
+}`, arq7FolderUuid, len(treeBlob), treePack, arq7SetUuid, arq7FolderUuid)
+	writeFile(t, filepath.Join(records, "00160", "7634962.backuprecord"), encrypt(lz4Frame(t, []byte(latest))))
+
+	// An older record, as an uncompressed XML plist like Arq 6 wrote, with
+	// leading whitespace.
+	older := fmt.Sprintf(`
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>arqVersion</key>
+	<string>6.2.11</string>
+	<key>backupFolderUUID</key>
+	<string>%s</string>
+	<key>creationDate</key>
+	<real>1600000000</real>
+	<key>isComplete</key>
+	<false/>
+	<key>node</key>
+	<dict>
+		<key>containedFilesCount</key>
+		<integer>1</integer>
+		<key>isTree</key>
+		<true/>
+		<key>mac_st_gid</key>
+		<integer>20</integer>
+		<key>mac_st_mode</key>
+		<integer>16877</integer>
+		<key>mac_st_uid</key>
+		<integer>501</integer>
+		<key>modificationTime_nsec</key>
+		<integer>0</integer>
+		<key>modificationTime_sec</key>
+		<integer>1599999000</integer>
+		<key>treeBlobLoc</key>
+		<dict>
+			<key>blobIdentifier</key>
+			<string>cc</string>
+			<key>compressionType</key>
+			<integer>2</integer>
+			<key>isPacked</key>
+			<true/>
+			<key>length</key>
+			<integer>%d</integer>
+			<key>offset</key>
+			<integer>7</integer>
+			<key>relativePath</key>
+			<string>%s</string>
+		</dict>
+	</dict>
+	<key>version</key>
+	<integer>12</integer>
+</dict>
+</plist>
+`, arq7FolderUuid, len(treeBlob), treePack)
+	writeFile(t, filepath.Join(records, "00160", "0000000.backuprecord"), encrypt([]byte(older)))
+}
+
+func TestBackupSet(t *testing.T) {
+	t.Run("Unencrypted", func(t *testing.T) {
+		testBackupSet(t, "")
+	})
+	t.Run("Encrypted", func(t *testing.T) {
+		testBackupSet(t, "hunter2")
+	})
+}
+
+func testBackupSet(t *testing.T, passphrase string) {
+	ctx := context.Background()
+	dir := copyT1(t)
+	writeArq7Set(t, dir, passphrase)
+	f, err := local.NewFs(ctx, "localfs", dir, configmap.New())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	t.Run("DetectFormat", func(t *testing.T) {
+		for _, tc := range []struct {
+			dir      string
+			expected arq.Format
+		}{
+			{arq7SetUuid, arq.FormatArq7},
+			{"8C10C697-7DCA-4747-B92B-6900CC64CCE7", arq.FormatArq5},
+			{"8C10C697-7DCA-4747-B92B-6900CC64CCE7/objects", arq.FormatUnknown},
+			{"missing", arq.FormatUnknown},
+		} {
+			format, err := arq.DetectFormat(ctx, f, tc.dir)
+			assert.Nil(t, err, tc.dir)
+			assert.Equal(t, tc.expected, format, tc.dir)
+		}
+	})
+
+	t.Run("ListComputers", func(t *testing.T) {
+		computers, err := arq.ListComputers(ctx, f, "")
+		if assert.Nil(t, err) && assert.Equal(t, 1, len(computers)) {
+			assert.Equal(t, "narrator", computers[0].Info.ComputerName)
+		}
+	})
+
+	sets, err := arq.ListBackupSets(ctx, f, "")
+	if !assert.Nil(t, err) || !assert.Equal(t, 1, len(sets)) {
+		return
+	}
+	bs := &sets[0]
+	assert.Equal(t, arq7SetUuid, bs.Uuid)
+	assert.Equal(t, "narrator", bs.Config.ComputerName)
+
+	_, err = bs.ListBackupFolders(ctx)
+	assert.ErrorIs(t, err, arq.ErrBackupSetNotOpen)
+	if passphrase != "" {
+		assert.NotNil(t, bs.Open(ctx, "wrong"))
+	}
+	if !assert.Nil(t, bs.Open(ctx, passphrase)) {
+		return
+	}
+	plan, err := bs.Plan(ctx)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, plan.Version)
+	}
+
+	folders, err := bs.ListBackupFolders(ctx)
+	if !assert.Nil(t, err) || !assert.Equal(t, 1, len(folders)) {
+		return
+	}
+	bf := &folders[0]
+	assert.Equal(t, "src", bf.Name)
+
+	entries, err := bf.ListBackupRecords(ctx)
+	if !assert.Nil(t, err) || !assert.Equal(t, 2, len(entries)) {
+		return
+	}
+	assert.Equal(t, int64(1607634962), entries[0].Name)
+	assert.Equal(t, int64(1600000000), entries[1].Name)
+
+	older, err := bf.BackupRecord(ctx, entries[1].Name)
+	if assert.Nil(t, err) {
+		assert.False(t, older.IsComplete)
+		assert.Equal(t, int64(1600000000), older.Created().Unix())
+		assert.Equal(t, int64(1599999000), older.Node.Mtime().Unix())
+		assert.Equal(t, uint32(501), older.Node.UserID)
+		assert.Equal(t, os.ModeDir|0755, older.Node.FileMode())
+		if assert.NotNil(t, older.Node.TreeBlobLoc) {
+			assert.Equal(t, arq.Lz4Compression, older.Node.TreeBlobLoc.CompressionType)
+			tree, err := bs.Tree(ctx, *older.Node.TreeBlobLoc)
+			if assert.Nil(t, err) && assert.Equal(t, 1, len(tree.Nodes)) {
+				assert.Equal(t, "hello.txt", tree.Nodes[0].Name)
+			}
+		}
+	}
+	record, err := bf.BackupRecord(ctx, entries[0].Name)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1607634962), record.Created().Unix())
+	assert.True(t, record.Node.IsTree)
+	assert.Equal(t, time.Unix(1607634900, 500000000), record.Node.Mtime())
+	assert.Equal(t, int64(1607634901), record.Node.ChangeTimeSec)
+	assert.Equal(t, int64(250000000), record.Node.ChangeTimeNsec)
+	assert.Equal(t, int64(1607634800), record.Node.CreationTimeSec)
+	assert.Equal(t, uint32(501), record.Node.UserID)
+	assert.Equal(t, uint32(20), record.Node.GroupID)
+	assert.Equal(t, uint64(8675309), record.Node.MacStIno)
+	assert.Equal(t, os.ModeDir|0755, record.Node.FileMode())
+	assert.True(t, record.Node.TreeBlobLoc.StretchEncryptionKey)
+
+	tree, err := bs.Tree(ctx, *record.Node.TreeBlobLoc)
+	if !assert.Nil(t, err) || !assert.Equal(t, 1, len(tree.Nodes)) {
+		return
+	}
+	node := tree.Nodes[0].Node
+	assert.Equal(t, "hello.txt", tree.Nodes[0].Name)
+	assert.Equal(t, "sholiday", node.UserName)
+	assert.Equal(t, os.FileMode(0644), node.FileMode())
+	assert.Equal(t, int64(1607634900), node.Mtime().Unix())
+
+	var data []byte
+	for _, loc := range node.DataBlobLocs {
+		rc, err := bs.ReadBlob(ctx, loc)
+		if !assert.Nil(t, err) {
+			return
+		}
+		by, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		rc.Close()
+		data = append(data, by...)
+	}
+	assert.Equal(t, "hello, world\n", string(data))
+}
+
+func TestArq7TreeVersions(t *testing.T) {
+	node := arq.Arq7Node{
+		IsTree:         true,
+		TreeBlobLoc:    &arq.Arq7BlobLoc{BlobIdentifier: "aa", IsPacked: true, IsLargePack: true, RelativePath: "/x/treepacks/00/00.pack", Length: 10},
+		AclBlobLoc:     &arq.Arq7BlobLoc{BlobIdentifier: "bb", CompressionType: arq.Lz4Compression},
+		XattrsBlobLocs: []arq.Arq7BlobLoc{{BlobIdentifier: "cc"}, {BlobIdentifier: "dd"}},
+		GroupName:      "staff",
+		WinReparseTag:  7,
+		MacStIno:       1 << 40,
+	}
+	for _, version := range []uint32{1, 2, 3} {
+		tree := arq.Arq7Tree{
+			Version: version,
+			Nodes:   []arq.Arq7TreeNode{{Name: "a", Node: node}, {Name: "b"}},
+		}
+		var buf bytes.Buffer
+		n, err := tree.MarshalArq(&buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, buf.Len(), n)
+		var actual arq.Arq7Tree
+		if !assert.Nil(t, arq.DecodeArq(&buf, &actual), version) {
+			continue
+		}
+		assert.Equal(t, 0, buf.Len())
+		expected := node
+		loc := *expected.TreeBlobLoc
+		if version < 3 {
+			loc.IsLargePack = false
+		}
+		expected.TreeBlobLoc = &loc
+		if version < 2 {
+			expected.WinReparseTag = 0
+		}
+		assert.Equal(t, expected, actual.Nodes[0].Node, version)
+		assert.Equal(t, "b", actual.Nodes[1].Name)
+	}
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/pack/indexcache"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// packCache is the index of every object stored in a pack.
+type packCache interface {
+	indexcache.Builder
+	indexcache.Searcher
+}
+
+// indexPacks brings the cache of c's pack indexes up to date and makes it c's
+// PackSearcher. Without -cache-dir, or if another run is using the cache,
+// every pack index is read into memory.
+func indexPacks(ctx context.Context, remote fs.Fs, c *arq.Computer) error {
+	var cache packCache = indexcache.NewMapBackedCache()
+	if *cacheDirFlag != "" {
+		pc, err := indexcache.OpenPersistentCache(ctx, cacheDir(remote, c))
+		switch {
+		case errors.Is(err, indexcache.ErrLocked):
+			fmt.Fprintf(os.Stderr, "arq: warning: the pack index cache of %s is in use, reading pack indexes into memory\n", c.Uuid)
+		case err != nil:
+			return err
+		default:
+			cache = pc
+		}
+	}
+	report, err := c.IndexPacks(ctx, cache, &arq.IndexPacksOptions{RebuildIndexes: *rebuildIndexesFlag})
+	if err != nil {
+		if !*rebuildIndexesFlag {
+			err = fmt.Errorf("%w (rerun with -rebuild-indexes to work around a corrupt pack index)", err)
+		}
+		return err
+	}
+	for _, pf := range report.Rebuilt {
+		fmt.Fprintf(os.Stderr, "arq: warning: rebuilt the missing or corrupt index of pack %s in %s\n", pf.Hash, pf.Packset)
+	}
+	for _, pf := range report.StrayPacks {
+		fmt.Fprintf(os.Stderr, "arq: warning: pack %s in %s has no index, rerun with -rebuild-indexes to read its objects\n", pf.Hash, pf.Packset)
+	}
+	return nil
+}
+
+// cacheDir returns the directory to cache the pack indexes of c in. The same
+// computer may be backed up to more than one remote, which needn't have the
+// same packs, so the directory is named for both.
+func cacheDir(remote fs.Fs, c *arq.Computer) string {
+	sum := sha1.Sum([]byte(fs.ConfigString(remote)))
+	return filepath.Join(*cacheDirFlag, fmt.Sprintf("%x-%s", sum[:8], c.Uuid))
+}
+
+// loadCommit loads a commit by its hash, or the most recent if name is
+// "latest".
+func loadCommit(ctx context.Context, f *arq.Folder, name string) (*arq.ArqCommit, error) {
+	h, err := resolveCommit(ctx, f, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Commit(ctx, h)
+}
+
+// resolveCommit returns the hash of a commit given by its hash, or "latest".
+func resolveCommit(ctx context.Context, f *arq.Folder, name string) (arq.ShaHash, error) {
+	if name == "latest" {
+		return f.FindMaster(ctx)
+	}
+	return arq.DecodeShaHashString(name)
+}
+
+func runComputers(ctx context.Context, e *env, args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "UUID\tCOMPUTER\tUSER\tFORMAT")
+	for _, c := range e.computers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Uuid, c.Info.ComputerName, c.Info.UserName, arq.FormatArq5)
+	}
+	sets, err := arq.ListBackupSets(ctx, e.f, "")
+	if err != nil {
+		return err
+	}
+	for _, bs := range sets {
+		fmt.Fprintf(w, "%s\t%s\t\t%s\n", bs.Uuid, bs.Config.ComputerName, arq.FormatArq7)
+	}
+	return w.Flush()
+}
+
+func runFolders(ctx context.Context, e *env, args []string) error {
+	folders, err := e.computer.ListFolders(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "UUID\tNAME\tPATH")
+	for _, fi := range folders {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", fi.BucketUuid, fi.BucketName, fi.LocalPath)
+	}
+	return w.Flush()
+}
+
+func runCommits(ctx context.Context, e *env, args []string) error {
+	snapshots, err := e.folder.Snapshots(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMIT\tCREATED\tSTATUS\tCOMMENT")
+	for _, s := range snapshots {
+		created, comment := "", ""
+		if !s.Date.IsZero() {
+			created = s.Date.Format(timeFormat)
+		}
+		if s.Commit != nil {
+			comment = s.Commit.Comment
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Hash, created, s.Status, comment)
+	}
+	return w.Flush()
+}
+
+func printNode(w *tabwriter.Writer, p string, node *arq.ArqNode) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", node.FileMode(), node.DataSize, node.Mtime.Format(timeFormat), p)
+}
+
+func runLs(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: ls <commit> [path]")
+	}
+	commit, err := loadCommit(ctx, e.folder, args[0])
+	if err != nil {
+		return err
+	}
+	dir := ""
+	if len(args) == 2 {
+		dir = strings.Trim(path.Clean("/"+args[1]), "/")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+	found := dir == ""
+	err = e.folder.Walk(ctx, commit, func(p string, node *arq.ArqNode, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case p == dir:
+			found = true
+			if !node.IsTree {
+				printNode(w, p, node)
+			}
+			return nil
+		case path.Dir(p) == dir || (dir == "" && !strings.Contains(p, "/")):
+			if err := e.folder.LoadDirMetadata(ctx, node); err != nil {
+				return err
+			}
+			printNode(w, path.Base(p), node)
+		case strings.HasPrefix(dir, p+"/"):
+			// An ancestor of the directory we're listing.
+			return nil
+		}
+		if node.IsTree {
+			return arq.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("'%s': %w", dir, arq.ErrNotFound)
+	}
+	return w.Flush()
+}
+
+func runCat(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("cat", flag.ContinueOnError)
+	offset := fl.Int64("offset", 0, "start writing from this byte offset")
+	length := fl.Int64("length", -1, "write at most this many bytes")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 2 || *offset < 0 {
+		return errors.New("usage: cat [-offset n] [-length n] <commit> <path>")
+	}
+	commit, err := loadCommit(ctx, e.folder, fl.Arg(0))
+	if err != nil {
+		return err
+	}
+	node, err := e.folder.Lookup(ctx, commit, fl.Arg(1))
+	if err != nil {
+		return err
+	}
+	if node.IsTree {
+		return fmt.Errorf("'%s' is a directory", fl.Arg(1))
+	}
+	if *offset == 0 && *length < 0 {
+		rc, err := e.folder.OpenNode(ctx, node, nil)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(os.Stdout, rc)
+		return err
+	}
+	// Only fetch the chunks covering the range.
+	f, err := e.folder.OpenFile(ctx, node)
+	if err != nil {
+		return err
+	}
+	if *offset > f.Size() {
+		return fmt.Errorf("offset %d is past the end of '%s', which has %d bytes", *offset, fl.Arg(1), f.Size())
+	}
+	n := f.Size() - *offset
+	if *length >= 0 && *length < n {
+		n = *length
+	}
+	_, err = io.Copy(os.Stdout, io.NewSectionReader(f, *offset, n))
+	return err
+}
+
+// diffNode is the metadata of a node in diff's JSON output.
+type diffNode struct {
+	Size  uint64    `json:"size"`
+	Mode  string    `json:"mode"`
+	Uid   int32     `json:"uid"`
+	Gid   int32     `json:"gid"`
+	Mtime time.Time `json:"mtime"`
+}
+
+func newDiffNode(node *arq.ArqNode) *diffNode {
+	if node == nil {
+		return nil
+	}
+	return &diffNode{
+		Size:  node.DataSize,
+		Mode:  node.FileMode().String(),
+		Uid:   node.Uid,
+		Gid:   node.Gid,
+		Mtime: node.Mtime,
+	}
+}
+
+func runDiff(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := fl.Bool("json", false, "write each change as a line of JSON")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 2 {
+		return errors.New("usage: diff [-json] <old commit> <new commit>")
+	}
+	a, err := loadCommit(ctx, e.folder, fl.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := loadCommit(ctx, e.folder, fl.Arg(1))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	return arq.Diff(ctx, e.folder, a, b, func(c arq.Change) error {
+		if *asJSON {
+			return enc.Encode(struct {
+				Kind   arq.ChangeKind `json:"kind"`
+				Path   string         `json:"path"`
+				Fields []string       `json:"fields,omitempty"`
+				Old    *diffNode      `json:"old,omitempty"`
+				New    *diffNode      `json:"new,omitempty"`
+			}{c.Kind, c.Path, c.Fields, newDiffNode(c.Old), newDiffNode(c.New)})
+		}
+		if len(c.Fields) > 0 {
+			_, err := fmt.Printf("%-8s %s (%s)\n", c.Kind, c.Path, strings.Join(c.Fields, ", "))
+			return err
+		}
+		_, err := fmt.Printf("%-8s %s\n", c.Kind, c.Path)
+		return err
+	})
+}
+
+func runVersions(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("versions", flag.ContinueOnError)
+	restore := fl.Int("restore", 0, "restore this version, numbered from 1, to -dest")
+	dest := fl.String("dest", "", "where to restore the version given by -restore")
+	overwrite := fl.Bool("overwrite", false, "replace dest if it already exists")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 1 || (*restore != 0) != (*dest != "") {
+		return errors.New("usage: versions [-restore n -dest file [-overwrite]] <path>")
+	}
+	versions, err := arq.History(ctx, e.folder, fl.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("'%s': %w", fl.Arg(0), arq.ErrNotFound)
+	}
+	if *restore != 0 {
+		if *restore < 0 || *restore > len(versions) {
+			return fmt.Errorf("there are %d versions of '%s'", len(versions), fl.Arg(0))
+		}
+		return arq.RestoreNode(ctx, e.folder, versions[*restore-1].Node, *dest, arq.RestoreOptions{Overwrite: *overwrite})
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tCOMMIT\tCREATED\tSIZE\tMODIFIED\tCOMMITS")
+	for i, v := range versions {
+		s := v.Commits[0]
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%d\n", i+1, s.Hash, s.Date.Format(timeFormat), v.Node.DataSize, v.Node.Mtime.Format(timeFormat), len(v.Commits))
+	}
+	return w.Flush()
+}
+
+// stringsFlag is a flag which may be given more than once.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(s string) error {
+	*sf = append(*sf, s)
+	return nil
+}
+
+// parseTime parses a date, or a date and time, in the local time zone.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(timeFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+func runFind(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("find", flag.ContinueOnError)
+	var globs, regexps stringsFlag
+	fl.Var(&globs, "name", "match names against this glob, may be repeated")
+	fl.Var(&regexps, "regex", "match names against this regular expression, may be repeated")
+	typ := fl.String("type", "", "only match files (f), directories (d) or symlinks (l)")
+	minSize := fl.Uint64("min-size", 0, "only match nodes of at least this many bytes")
+	maxSize := fl.Uint64("max-size", 0, "only match nodes of at most this many bytes")
+	after := fl.String("after", "", "only match nodes modified at or after this date, as YYYY-MM-DD [HH:MM:SS]")
+	before := fl.String("before", "", "only match nodes modified before this date")
+	commitName := fl.String("commit", "", "only search this commit, rather than every commit")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 0 {
+		return errors.New("usage: find [-name glob] [-regex re] [-type f|d|l] [-min-size n] [-max-size n] [-after date] [-before date] [-commit commit]")
+	}
+
+	q := arq.FindQuery{Globs: globs, MinSize: *minSize, MaxSize: *maxSize}
+	for _, r := range regexps {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return err
+		}
+		q.Regexps = append(q.Regexps, re)
+	}
+	switch *typ {
+	case "":
+	case "f":
+		q.Type = arq.FileNode
+	case "d":
+		q.Type = arq.DirNode
+	case "l":
+		q.Type = arq.SymlinkNode
+	default:
+		return fmt.Errorf("unknown -type '%s', expected f, d or l", *typ)
+	}
+	var err error
+	if *after != "" {
+		if q.After, err = parseTime(*after); err != nil {
+			return err
+		}
+	}
+	if *before != "" {
+		if q.Before, err = parseTime(*before); err != nil {
+			return err
+		}
+	}
+	fd, err := arq.NewFinder(q)
+	if err != nil {
+		return err
+	}
+
+	if err := indexPacks(ctx, e.f, e.computer); err != nil {
+		return err
+	}
+	folders, err := e.computer.ListFolders(ctx)
+	if err != nil {
+		return err
+	}
+	if *folderFlag != "" {
+		fi, err := selectFolder(folders, *folderFlag)
+		if err != nil {
+			return err
+		}
+		folders = []arq.FolderInfo{*fi}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+	foundCommit := false
+	for i := range folders {
+		f := folders[i].Folder()
+		snapshots, err := f.Snapshots(ctx)
+		if err != nil {
+			return fmt.Errorf("folder %s: %w", folders[i].BucketName, err)
+		}
+		if *commitName != "" {
+			// Commits are stored by computer, so another folder's commit
+			// could be loaded, but only this folder's are searched.
+			want, err := resolveCommit(ctx, f, *commitName)
+			if err != nil {
+				return err
+			}
+			var only []arq.Snapshot
+			for _, s := range snapshots {
+				if s.Hash == want {
+					only = append(only, s)
+				}
+			}
+			snapshots = only
+			foundCommit = foundCommit || len(only) > 0
+		}
+		for _, s := range snapshots {
+			if s.Commit == nil {
+				continue
+			}
+			err := fd.Find(ctx, f, s.Commit, func(p string, node *arq.ArqNode) error {
+				_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", folders[i].BucketName, s.Hash.String()[:8], s.Date.Format(timeFormat), node.FileMode(), node.DataSize, node.Mtime.Format(timeFormat), p)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("folder %s, commit %s: %w", folders[i].BucketName, s.Hash, err)
+			}
+		}
+	}
+	if *commitName != "" && !foundCommit {
+		return fmt.Errorf("commit %s isn't a snapshot of any folder searched", *commitName)
+	}
+	return w.Flush()
+}
+
+func runRestore(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("restore", flag.ContinueOnError)
+	overwrite := fl.Bool("overwrite", false, "replace files which already exist")
+	ownership := fl.Bool("ownership", false, "restore file owners and groups, if permitted")
+	xattrs := fl.Bool("xattrs", false, "restore extended attributes (Linux only)")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 2 {
+		return errors.New("usage: restore [-overwrite] [-ownership] [-xattrs] <commit> <dest>")
+	}
+	commit, err := loadCommit(ctx, e.folder, fl.Arg(0))
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = arq.Restore(ctx, e.folder, commit, fl.Arg(1), arq.RestoreOptions{
+		Overwrite: *overwrite,
+		Ownership: *ownership,
+		XAttrs:    *xattrs,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %s to %s in %s\n", fl.Arg(0), fl.Arg(1), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func runExport(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fl.String("format", "tar", "the archive format: tar, tar.gz or zip")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() < 1 || fl.NArg() > 2 {
+		return errors.New("usage: export [-format tar|tar.gz|zip] <commit> [path] > archive")
+	}
+	commit, err := loadCommit(ctx, e.folder, fl.Arg(0))
+	if err != nil {
+		return err
+	}
+	// Buffered, as archive writers make many small writes.
+	w := bufio.NewWriter(os.Stdout)
+	switch *format {
+	case "tar":
+		err = arq.ExportTar(ctx, w, e.folder, commit, fl.Arg(1))
+	case "tar.gz", "tgz":
+		gw := gzip.NewWriter(w)
+		if err = arq.ExportTar(ctx, gw, e.folder, commit, fl.Arg(1)); err == nil {
+			err = gw.Close()
+		}
+	case "zip":
+		err = arq.ExportZip(ctx, w, e.folder, commit, fl.Arg(1))
+	default:
+		return fmt.Errorf("unknown -format '%s', expected tar, tar.gz or zip", *format)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func runVerify(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: verify")
+	}
+	start := time.Now()
+	report, err := e.folder.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBLEM\tTYPE\tOBJECT\tCOMMIT\tPATH\tERROR")
+		for _, p := range report.Problems {
+			commit := ""
+			if p.Commit != (arq.ShaHash{}) {
+				commit = p.Commit.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\n", p.Kind, p.Type, p.Hash, commit, p.Path, p.Err)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "verified %d commits, %d trees, %d blobs and %d packs in %s\n",
+		report.Commits, report.Trees, report.Blobs, report.Packs, time.Since(start).Round(time.Millisecond))
+	if !report.OK() {
+		return fmt.Errorf("found %d problems", len(report.Problems))
+	}
+	return nil
+}
+
+func runRebuildIndexes(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("rebuild-indexes", flag.ContinueOnError)
+	all := fl.Bool("all", false, "rebuild the index of every pack, not just missing or corrupt ones")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 1 {
+		return errors.New("usage: rebuild-indexes [-all] <dest>")
+	}
+	packsets, err := e.computer.ListPacksets(ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, ps := range packsets {
+		packs, err := e.computer.ListPacks(ctx, ps)
+		if err != nil {
+			return err
+		}
+		for _, pf := range packs {
+			if pf.Pack == nil {
+				continue
+			}
+			if pf.Index != nil && !*all {
+				rc, err := pf.Index.Open(ctx)
+				if err != nil {
+					return err
+				}
+				var pi arq.ArqPackIndex
+				err = arq.DecodeArq(rc, &pi)
+				rc.Close()
+				if err == nil {
+					continue
+				}
+			}
+			pi, err := e.computer.RebuildPackIndex(ctx, pf.Pack)
+			if err != nil {
+				return err
+			}
+			if err := writePackIndex(filepath.Join(fl.Arg(0), ps, pf.Hash.String()+".index"), pi); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "rebuilt %d pack indexes into %s\n", n, fl.Arg(0))
+	return nil
+}
+
+func writePackIndex(fname string, pi *arq.ArqPackIndex) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	if _, err := pi.MarshalArq(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runChangePassphrase(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("change-passphrase", flag.ContinueOnError)
+	newFile := fl.String("new-passphrase-file", "", "file containing the new passphrase")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 0 {
+		return errors.New("usage: change-passphrase [-new-passphrase-file <file>]")
+	}
+	c, err := selectComputer(e.computers, *computerFlag)
+	if err != nil {
+		return err
+	}
+	oldPassphrase, err := readPassphrase()
+	if err != nil {
+		return err
+	}
+	newPassphrase, err := readNewPassphrase(*newFile)
+	if err != nil {
+		return err
+	}
+	backup, err := c.ChangePassphrase(ctx, oldPassphrase, newPassphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "changed the passphrase of %s, the previous encryptionv3.dat is backed up to %s\n", c.Uuid, backup)
+	return nil
+}
+
+// readNewPassphrase reads the new passphrase from fname, the
+// ARQ_NEW_PASSPHRASE environment variable, or prompts for it twice.
+func readNewPassphrase(fname string) (string, error) {
+	if fname != "" {
+		by, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(by), "\r\n"), nil
+	}
+	if p, ok := os.LookupEnv("ARQ_NEW_PASSPHRASE"); ok {
+		return p, nil
+	}
+	fmt.Fprint(os.Stderr, "New passphrase: ")
+	p := config.ReadPassword()
+	fmt.Fprint(os.Stderr, "Confirm new passphrase: ")
+	if config.ReadPassword() != p {
+		return "", errors.New("passphrases don't match")
+	}
+	if p == "" {
+		return "", errors.New("the new passphrase is empty")
+	}
+	return p, nil
+}
@@ -0,0 +1,244 @@
+// Command arq explores and restores Arq backups stored on any rclone remote.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/rclone/rclone/backend/all"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configfile"
+	"github.com/sholiday/arq"
+)
+
+const usage = `Usage: arq [flags] <remote:path> <command> [args]
+
+Commands:
+  computers                  list the computers backed up to the remote, and
+                             any Arq 6 or 7 backup sets
+  folders                    list the folders backed up by a computer
+  commits                    list the commits of a folder, including those only
+                             in the reflog, and whether each still exists
+  ls <commit> [path]         list the contents of a directory in a commit
+  cat <commit> <path>        write the contents of a file in a commit to stdout,
+                             or only part of it with -offset and -length
+  diff <old> <new>           list what changed between two commits, as JSON
+                             with -json
+  versions <path>            list every version of a file across all commits,
+                             or restore one with -restore n -dest file
+  find                       search every commit of every folder, or those
+                             given by -commit and -folder, for nodes by name
+                             (-name, -regex), -type, size and mtime
+  restore <commit> <dest>    restore a commit into the local directory dest
+  export <commit> [path]     write a commit, or only path within it, to stdout
+                             as a -format tar (default), tar.gz or zip archive
+  verify                     check that every commit of a folder can be restored
+  rebuild-indexes <dest>     rebuild missing or corrupt pack indexes into dest
+  change-passphrase          change the passphrase of a computer, reading the
+                             new one from -new-passphrase-file,
+                             ARQ_NEW_PASSPHRASE, or prompting for it
+  mount <mountpoint>         mount the history of every computer with FUSE
+
+A <commit> is either the SHA1 of a commit or "latest".
+
+The passphrase is read from -passphrase-file, the ARQ_PASSPHRASE environment
+variable, or prompted for, in that order.
+
+Flags:
+`
+
+var (
+	computerFlag       = flag.String("computer", "", "UUID or name of the computer, required if there is more than one")
+	folderFlag         = flag.String("folder", "", "UUID or name of the folder, required if there is more than one")
+	passphraseFileFlag = flag.String("passphrase-file", "", "file containing the encryption passphrase")
+	cacheDirFlag       = flag.String("cache-dir", defaultCacheDir(), "directory to cache pack indexes in between runs, empty to keep them in memory")
+	rebuildIndexesFlag = flag.Bool("rebuild-indexes", false, "rebuild missing or corrupt pack indexes in memory by reading their packs, which is slow")
+)
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "arq")
+}
+
+type command struct {
+	run func(ctx context.Context, e *env, args []string) error
+	// Whether the command needs an opened computer, and also a folder.
+	needsComputer bool
+	needsFolder   bool
+}
+
+var commands = map[string]command{
+	"computers": {run: runComputers},
+	"folders":   {run: runFolders, needsComputer: true},
+	"commits":   {run: runCommits, needsComputer: true, needsFolder: true},
+	"ls":        {run: runLs, needsComputer: true, needsFolder: true},
+	"cat":       {run: runCat, needsComputer: true, needsFolder: true},
+	"diff":      {run: runDiff, needsComputer: true, needsFolder: true},
+	"versions":  {run: runVersions, needsComputer: true, needsFolder: true},
+	"restore":   {run: runRestore, needsComputer: true, needsFolder: true},
+	"export":    {run: runExport, needsComputer: true, needsFolder: true},
+	"verify":    {run: runVerify, needsComputer: true, needsFolder: true},
+
+	"rebuild-indexes": {run: runRebuildIndexes, needsComputer: true},
+	// Searches every folder, unless -folder is given.
+	"find": {run: runFind, needsComputer: true},
+	// Opens the computer itself, as it needs both passphrases.
+	"change-passphrase": {run: runChangePassphrase},
+}
+
+// env is everything a command might need, opened as far as the command
+// requires.
+type env struct {
+	f         fs.Fs
+	computers []arq.Computer
+	computer  *arq.Computer
+	folder    *arq.Folder
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(1)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "arq: unknown command '%s'\n", flag.Arg(1))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	e, err := openEnv(ctx, flag.Arg(0), cmd)
+	if err == nil {
+		err = cmd.run(ctx, e, flag.Args()[2:])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arq: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func openEnv(ctx context.Context, remote string, cmd command) (*env, error) {
+	// Local paths work without any configuration, so don't let rclone
+	// complain if there isn't any.
+	ci := fs.GetConfig(ctx)
+	logLevel := ci.LogLevel
+	ci.LogLevel = fs.LogLevelError
+	configfile.LoadConfig(ctx)
+	ci.LogLevel = logLevel
+	f, err := fs.NewFs(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	e := &env{f: f}
+	if e.computers, err = arq.ListComputers(ctx, f, ""); err != nil {
+		return nil, err
+	}
+	if !cmd.needsComputer {
+		return e, nil
+	}
+
+	if e.computer, err = selectComputer(e.computers, *computerFlag); err != nil {
+		return nil, err
+	}
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.needsFolder {
+		return e, e.computer.Open(ctx, passphrase)
+	}
+
+	if err := openComputer(ctx, f, e.computer, passphrase); err != nil {
+		return nil, err
+	}
+	folders, err := e.computer.ListFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := selectFolder(folders, *folderFlag)
+	if err != nil {
+		return nil, err
+	}
+	e.folder = fi.Folder()
+	return e, nil
+}
+
+// openComputer unlocks c and indexes its packs, so that its objects can be
+// read.
+func openComputer(ctx context.Context, remote fs.Fs, c *arq.Computer, passphrase string) error {
+	if err := c.Open(ctx, passphrase); err != nil {
+		return err
+	}
+	return indexPacks(ctx, remote, c)
+}
+
+func selectComputer(computers []arq.Computer, want string) (*arq.Computer, error) {
+	var found []*arq.Computer
+	for i := range computers {
+		c := &computers[i]
+		if want == "" || strings.EqualFold(c.Uuid, want) || c.Info.ComputerName == want {
+			found = append(found, c)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case len(found) == 0 && want != "":
+		return nil, fmt.Errorf("no computer matches '%s'", want)
+	case len(found) == 0:
+		return nil, errors.New("no computers found")
+	default:
+		return nil, errors.New("more than one computer found, choose one with -computer")
+	}
+}
+
+func selectFolder(folders []arq.FolderInfo, want string) (*arq.FolderInfo, error) {
+	var found []*arq.FolderInfo
+	for i := range folders {
+		fi := &folders[i]
+		if want == "" || strings.EqualFold(fi.BucketUuid, want) || fi.BucketName == want {
+			found = append(found, fi)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case len(found) == 0 && want != "":
+		return nil, fmt.Errorf("no folder matches '%s'", want)
+	case len(found) == 0:
+		return nil, errors.New("no folders found")
+	default:
+		return nil, errors.New("more than one folder found, choose one with -folder")
+	}
+}
+
+func readPassphrase() (string, error) {
+	if *passphraseFileFlag != "" {
+		by, err := ioutil.ReadFile(*passphraseFileFlag)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(by), "\r\n"), nil
+	}
+	if p, ok := os.LookupEnv("ARQ_PASSPHRASE"); ok {
+		return p, nil
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	return config.ReadPassword(), nil
+}
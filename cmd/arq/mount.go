@@ -0,0 +1,70 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/mount"
+)
+
+func init() {
+	commands["mount"] = command{run: runMount}
+}
+
+func runMount(ctx context.Context, e *env, args []string) error {
+	fl := flag.NewFlagSet("mount", flag.ContinueOnError)
+	debug := fl.Bool("debug", false, "log every FUSE request")
+	allowOther := fl.Bool("allow-other", false, "let other users access the mount")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	if fl.NArg() != 1 {
+		return errors.New("usage: mount [-debug] [-allow-other] <mountpoint>")
+	}
+
+	var computers []*arq.Computer
+	if *computerFlag != "" {
+		c, err := selectComputer(e.computers, *computerFlag)
+		if err != nil {
+			return err
+		}
+		computers = append(computers, c)
+	} else {
+		for i := range e.computers {
+			computers = append(computers, &e.computers[i])
+		}
+	}
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return err
+	}
+	for _, c := range computers {
+		if err := openComputer(ctx, e.f, c, passphrase); err != nil {
+			return err
+		}
+	}
+
+	server, err := mount.Mount(fl.Arg(0), computers, mount.Options{
+		Debug:      *debug,
+		AllowOther: *allowOther,
+	})
+	if err != nil {
+		return err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		server.Unmount()
+	}()
+	server.Wait()
+	return nil
+}
@@ -0,0 +1,58 @@
+package arq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+var ErrInvalidCompressionType = errors.New("invalid CompressionType")
+
+// Arq prefixes each LZ4 block with its decompressed length, refuse to
+// allocate anything larger than this.
+const maxLz4DecompressedLen = 1 << 30
+
+// NewDecompressingReader returns a reader of r's contents, decompressed
+// according to ct.
+func NewDecompressingReader(r io.Reader, ct CompressionType) (io.Reader, error) {
+	switch ct {
+	case NoneCompression:
+		return r, nil
+	case GzipCompression:
+		return gzip.NewReader(r)
+	case Lz4Compression:
+		return newLz4Reader(r)
+	default:
+		return nil, fmt.Errorf("%w %d", ErrInvalidCompressionType, int32(ct))
+	}
+}
+
+// Arq writes LZ4 data as a 4 byte big endian decompressed length followed by
+// a single raw LZ4 block, which can't be decompressed incrementally.
+func newLz4Reader(r io.Reader) (io.Reader, error) {
+	var length int32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < 0 || length > maxLz4DecompressedLen {
+		return nil, fmt.Errorf("invalid LZ4 decompressed length %d", length)
+	}
+	compressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	n, err := lz4.UncompressBlock(compressed, buf)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing LZ4 block: %w", err)
+	}
+	if n != int(length) {
+		return nil, fmt.Errorf("LZ4 block decompressed to %d bytes, expected %d", n, length)
+	}
+	return bytes.NewReader(buf), nil
+}
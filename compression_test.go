@@ -0,0 +1,78 @@
+package arq_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompress(t *testing.T) {
+	expected := []byte("There are two hard things in computer science.")
+
+	t.Run("None", func(t *testing.T) {
+		r, err := arq.NewDecompressingReader(bytes.NewReader(expected), arq.NoneCompression)
+		if !assert.Nil(t, err) {
+			return
+		}
+		actual, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+	t.Run("Gzip", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		gw := gzip.NewWriter(buf)
+		_, err := gw.Write(expected)
+		assert.Nil(t, err)
+		assert.Nil(t, gw.Close())
+
+		r, err := arq.NewDecompressingReader(buf, arq.GzipCompression)
+		if !assert.Nil(t, err) {
+			return
+		}
+		actual, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+	t.Run("LZ4", func(t *testing.T) {
+		file, err := os.Open("testdata/crypt/encryptionv3.dat.bin")
+		if !assert.Nil(t, err) {
+			return
+		}
+		enc, err := arq.Unlock(context.Background(), file, "hunter2")
+		if !assert.Nil(t, err) {
+			return
+		}
+		// Object 1 is the LZ4 compressed tree from testdata/types/1.tree.
+		by, err := ioutil.ReadFile("testdata/crypt/object.1.bin")
+		if !assert.Nil(t, err) {
+			return
+		}
+		expected, err := ioutil.ReadFile("testdata/types/1.tree")
+		if !assert.Nil(t, err) {
+			return
+		}
+		r, err := arq.NewDecompressingReader(arq.NewEObjectReader(bytes.NewReader(by), enc), arq.Lz4Compression)
+		if !assert.Nil(t, err) {
+			return
+		}
+		actual, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+	t.Run("LZ4Truncated", func(t *testing.T) {
+		in := []byte{0x00, 0x00, 0x00, 0x10, 0xf0}
+		_, err := arq.NewDecompressingReader(bytes.NewReader(in), arq.Lz4Compression)
+		assert.NotNil(t, err)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := arq.NewDecompressingReader(bytes.NewReader(expected), arq.CompressionType(7))
+		assert.ErrorIs(t, err, arq.ErrInvalidCompressionType)
+	})
+}
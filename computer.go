@@ -1,12 +1,17 @@
 package arq
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"path"
 	"regexp"
+	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
 	"howett.net/plist"
 )
 
@@ -52,10 +57,14 @@ func ListComputers(ctx context.Context, f fs.Fs, base string) ([]Computer, error
 		c := Computer{
 			Uuid:   path.Base(d.String()),
 			opened: false,
-			base:   base,
+			base:   d.Remote(),
 			fs:     f,
 		}
 		cInfo, err := parseComputerInfo(ctx, f, d.String())
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			// Not an Arq 5 computer, perhaps an Arq 7 BackupSet.
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -67,6 +76,7 @@ func ListComputers(ctx context.Context, f fs.Fs, base string) ([]Computer, error
 
 func NewComputer(fs fs.Fs, base string) *Computer {
 	return &Computer{
+		Uuid:   path.Base(base),
 		opened: false,
 		base:   base,
 		fs:     fs,
@@ -77,10 +87,11 @@ type Computer struct {
 	Uuid string
 	Info ComputerInfo
 
-	opened bool
-	base   string
-	fs     fs.Fs
-	enc    *encryptionV3
+	opened  bool
+	base    string
+	fs      fs.Fs
+	enc     objectCipher
+	objects *ObjectStore
 }
 
 func (c *Computer) Open(ctx context.Context, passphrase string) error {
@@ -90,6 +101,20 @@ func (c *Computer) Open(ctx context.Context, passphrase string) error {
 	return nil
 }
 
+// SetPackSearcher sets the PackSearcher used to find objects stored in packs.
+func (c *Computer) SetPackSearcher(s PackSearcher) {
+	c.objects = NewObjectStore(c, s)
+}
+
+// Objects returns the ObjectStore for this computer. Until SetPackSearcher
+// has been called it can only find loose objects.
+func (c *Computer) Objects() *ObjectStore {
+	if c.objects == nil {
+		c.objects = NewObjectStore(c, nil)
+	}
+	return c.objects
+}
+
 func (c *Computer) NewObject(ctx context.Context, p string) (fs.Object, error) {
 	return c.fs.NewObject(ctx, path.Join(c.base, p))
 }
@@ -99,16 +124,60 @@ func (c *Computer) List(ctx context.Context, dir string) (fs.DirEntries, error)
 }
 
 func (c *Computer) unlock(ctx context.Context, passphrase string) error {
-	obj, err := c.fs.NewObject(ctx, path.Join(c.base, "encryptionv3.dat"))
+	enc, err := unlockComputer(ctx, c.fs, c.base, passphrase)
 	if err != nil {
 		return err
 	}
+	c.enc = enc
+	return nil
+}
+
+// ChangePassphrase re-encrypts the computer's keys under a new passphrase,
+// replacing its encryptionv3.dat. The keys themselves are unchanged, so
+// nothing else needs to be re-encrypted. The previous encryptionv3.dat is
+// first copied alongside it, and the path of that backup returned.
+func (c *Computer) ChangePassphrase(ctx context.Context, oldPassphrase, newPassphrase string) (string, error) {
+	obj, err := c.NewObject(ctx, "encryptionv3.dat")
+	if err != nil {
+		return "", err
+	}
 	rc, err := obj.Open(ctx)
 	if err != nil {
-		return err
+		return "", err
 	}
-	c.enc, err = Unlock(ctx, rc, passphrase)
-	return err
+	old, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return "", err
+	}
+	enc, err := Unlock(ctx, io.NopCloser(bytes.NewReader(old)), oldPassphrase)
+	if err != nil {
+		return "", err
+	}
+	rewrapped, err := enc.rewrap(newPassphrase)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if _, err := rewrapped.MarshalArq(&buf); err != nil {
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", obj.Remote(), time.Now().UTC().Format("20060102T150405Z"))
+	info := object.NewStaticObjectInfo(backup, obj.ModTime(ctx), int64(len(old)), true, nil, c.fs)
+	if _, err := c.fs.Put(ctx, bytes.NewReader(old), info); err != nil {
+		return "", fmt.Errorf("backing up encryptionv3.dat: %w", err)
+	}
+	info = object.NewStaticObjectInfo(obj.Remote(), time.Now(), int64(buf.Len()), true, nil, c.fs)
+	if err := obj.Update(ctx, bytes.NewReader(buf.Bytes()), info); err != nil {
+		return backup, err
+	}
+
+	// Make sure what was written can be unlocked, before anyone relies on it.
+	if err := c.unlock(ctx, newPassphrase); err != nil {
+		return backup, fmt.Errorf("the new encryptionv3.dat can't be unlocked, restore it from %s: %w", backup, err)
+	}
+	return backup, nil
 }
 
 type ComputerInfo struct {
@@ -138,7 +207,7 @@ func (c *Computer) ListFolders(ctx context.Context) ([]FolderInfo, error) {
 			return nil, err
 		}
 		defer rc.Close()
-		eor := NewEObjectReader(rc, c.enc)
+		eor := c.enc.decryptingReader(rc)
 		by, err := io.ReadAll(eor)
 		if err != nil {
 			return nil, err
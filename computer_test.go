@@ -3,11 +3,14 @@ package arq_test
 import (
 	"context"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,6 +37,7 @@ func TestComputer(t *testing.T) {
 		assert.Equal(t, computerUuid, computers[0].Uuid)
 		assert.Equal(t, "narrator", computers[0].Info.ComputerName)
 		assert.Equal(t, "sholiday", computers[0].Info.UserName)
+		assert.Nil(t, computers[0].Open(ctx, "hunter2"))
 	})
 
 	c := arq.NewComputer(localFs, computerUuid)
@@ -55,3 +59,48 @@ func TestComputer(t *testing.T) {
 		assert.Equal(t, computerUuid, folders[0].ComputerUuid)
 	})
 }
+
+func TestChangePassphrase(t *testing.T) {
+	ctx := context.Background()
+	dir := copyT1(t)
+	c := t1.NewComputer(t, dir)
+	if c == nil {
+		return
+	}
+	_, err := c.ChangePassphrase(ctx, "wrong", "hunter3")
+	assert.NotNil(t, err)
+
+	backup, err := c.ChangePassphrase(ctx, "hunter2", "hunter3")
+	if !assert.Nil(t, err) {
+		return
+	}
+	folders, err := c.ListFolders(ctx)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, len(folders))
+	}
+
+	// Only the new passphrase opens the computer, and its objects are
+	// unchanged.
+	localFs, err := local.NewFs(ctx, "localfs", dir, configmap.New())
+	if !assert.Nil(t, err) {
+		return
+	}
+	c = arq.NewComputer(localFs, "8C10C697-7DCA-4747-B92B-6900CC64CCE7")
+	assert.NotNil(t, c.Open(ctx, "hunter2"))
+	if !assert.Nil(t, c.Open(ctx, "hunter3")) {
+		return
+	}
+	h, _ := arq.DecodeShaHashString("ac7231f769fbe67c5c47fb0e5d98386b67dc6ea3")
+	rc, err := c.Objects().GetRaw(ctx, h)
+	if assert.Nil(t, err) {
+		rc.Close()
+	}
+
+	// The backup still opens with the old passphrase.
+	f, err := os.Open(filepath.Join(dir, backup))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = arq.Unlock(ctx, f, "hunter2")
+	assert.Nil(t, err)
+}
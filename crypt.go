@@ -7,6 +7,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
@@ -14,7 +15,6 @@ import (
 	"fmt"
 	"hash"
 	"io"
-	"log"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -57,11 +57,9 @@ func Unlock(ctx context.Context, reader io.ReadCloser, passphrase string) (*encr
 	if err != nil {
 		return nil, err
 	}
-	derived := pbkdf2.Key([]byte(passphrase), e.salt[:], 200000, 64, sha1.New)
-	if len(derived) != 64 {
-		return nil, fmt.Errorf("failed to derive key, unexpected length %d", len(derived))
+	if err := e.deriveKey(passphrase); err != nil {
+		return nil, err
 	}
-	copy(e.derivedKey[:], derived)
 
 	v, err := e.verifyHmac()
 	if err != nil {
@@ -79,6 +77,136 @@ func Unlock(ctx context.Context, reader io.ReadCloser, passphrase string) (*encr
 	return &e, nil
 }
 
+var arqKeySetHeader = []byte("ARQ_ENCRYPTED_MASTER_KEYS")
+
+// UnlockKeySet unlocks the `encryptedkeyset.dat` of an Arq 6 or 7 backup set.
+// Its keys are derived with PBKDF2-SHA256 rather than SHA1, and hold the keys
+// used for ARQO objects, which are otherwise encrypted just as in Arq 5, so
+// the result works with NewEObjectReader and NewEObjectWriter.
+func UnlockKeySet(ctx context.Context, reader io.ReadCloser, passphrase string) (*encryptionV3, error) {
+	defer reader.Close()
+	by, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	// The header, then an 8 byte salt, 32 byte HMAC and 16 byte IV.
+	hl := len(arqKeySetHeader)
+	if len(by) < hl+56+aes.BlockSize || (len(by)-hl-56)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted keyset length %d", len(by))
+	}
+	if !bytes.Equal(by[:hl], arqKeySetHeader) {
+		return nil, fmt.Errorf("invalid encrypted keyset header '% x', expected '% x'", by[:hl], arqKeySetHeader)
+	}
+	salt, mac, iv, encKeys := by[hl:hl+8], by[hl+8:hl+40], by[hl+40:hl+56], by[hl+56:]
+
+	derived := pbkdf2.Key([]byte(passphrase), salt, 200000, 64, sha256.New)
+	h := hmac.New(sha256.New, derived[32:])
+	h.Write(iv)
+	h.Write(encKeys)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, fmt.Errorf("invalid password")
+	}
+
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(encKeys))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, encKeys)
+	if plain, err = pkcs7Unpad(plain, aes.BlockSize); err != nil {
+		return nil, fmt.Errorf("encrypted keyset: %w", err)
+	}
+
+	r := bytes.NewReader(plain)
+	var version uint32
+	if err := DecodeArq(r, &version); err != nil {
+		return nil, err
+	}
+	if version != 3 {
+		return nil, fmt.Errorf("unsupported encrypted keyset version %d", version)
+	}
+	e := &encryptionV3{}
+	var keys struct {
+		EncryptionKey      []byte `arq:"len-uint64"`
+		HmacKey            []byte `arq:"len-uint64"`
+		BlobIdentifierSalt []byte `arq:"len-uint64"`
+	}
+	if err := DecodeArq(r, &keys); err != nil {
+		return nil, fmt.Errorf("encrypted keyset: %w", err)
+	}
+	encryptionKey, hmacKey := keys.EncryptionKey, keys.HmacKey
+	e.blobIdentifierSalt = keys.BlobIdentifierSalt
+	if len(encryptionKey) != len(e.key1) || len(hmacKey) != len(e.key2) {
+		return nil, fmt.Errorf("encrypted keyset has keys of length %d and %d, expected %d", len(encryptionKey), len(hmacKey), len(e.key1))
+	}
+	copy(e.key1[:], encryptionKey)
+	copy(e.key2[:], hmacKey)
+	return e, nil
+}
+
+func (e *encryptionV3) deriveKey(passphrase string) error {
+	derived := pbkdf2.Key([]byte(passphrase), e.salt[:], 200000, 64, sha1.New)
+	if len(derived) != 64 {
+		return fmt.Errorf("failed to derive key, unexpected length %d", len(derived))
+	}
+	copy(e.derivedKey[:], derived)
+	return nil
+}
+
+// rewrap returns a copy of e holding the same keys, but encrypted under a new
+// passphrase with a fresh salt and IV.
+func (e *encryptionV3) rewrap(passphrase string) (*encryptionV3, error) {
+	n := &encryptionV3{
+		header: e.header,
+		key1:   e.key1,
+		key2:   e.key2,
+		key3:   e.key3,
+
+		hasKey3: e.hasKey3,
+	}
+	if _, err := io.ReadFull(rand.Reader, n.salt[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, n.iv[:]); err != nil {
+		return nil, err
+	}
+	if err := n.deriveKey(passphrase); err != nil {
+		return nil, err
+	}
+	var keys []byte
+	keys = append(keys, n.key1[:]...)
+	keys = append(keys, n.key2[:]...)
+	if n.hasKey3 {
+		keys = append(keys, n.key3[:]...)
+	}
+	n.decKeys = pkcs7Pad(keys, aes.BlockSize)
+
+	block, err := aes.NewCipher(n.derivedKey[:32])
+	if err != nil {
+		return nil, err
+	}
+	n.encKeys = make([]byte, len(n.decKeys))
+	cipher.NewCBCEncrypter(block, n.iv[:]).CryptBlocks(n.encKeys, n.decKeys)
+
+	mac := hmac.New(sha256.New, n.derivedKey[32:])
+	mac.Write(n.iv[:])
+	mac.Write(n.encKeys)
+	copy(n.hmac[:], mac.Sum(nil))
+	return n, nil
+}
+
+// MarshalArq encodes e in the format of encryptionv3.dat.
+func (e *encryptionV3) MarshalArq(w io.Writer) (int, error) {
+	n := 0
+	for _, b := range [][]byte{e.header[:], e.salt[:], e.hmac[:], e.iv[:], e.encKeys} {
+		if err := writeAll(b, w); err != nil {
+			return n, err
+		}
+		n += len(b)
+	}
+	return n, nil
+}
+
 func writeAll(b []byte, w io.Writer) error {
 	for i := 0; i < len(b); {
 		n, err := w.Write(b[i:])
@@ -118,6 +246,25 @@ type encryptionV3 struct {
 	key1 [32]byte
 	key2 [32]byte
 	key3 [32]byte
+	// Whether key3 is used to hash objects, which Arq 4 didn't do.
+	hasKey3 bool
+
+	// Only set for Arq 6 and 7 keysets, whose blob identifiers are the
+	// SHA256 of this followed by the blob's contents.
+	blobIdentifierSalt []byte
+}
+
+// objectHash returns the hash an object with the given decompressed contents
+// is stored under, which is keyed so that it reveals nothing about them.
+func (e *encryptionV3) objectHash(data []byte) ShaHash {
+	h := sha1.New()
+	if e.hasKey3 {
+		h.Write(e.key3[:])
+	}
+	h.Write(data)
+	var sh ShaHash
+	copy(sh.Contents[:], h.Sum(nil))
+	return sh
 }
 
 func (e *encryptionV3) decryptKeys() error {
@@ -129,15 +276,27 @@ func (e *encryptionV3) decryptKeys() error {
 	e.decKeys = make([]byte, len(e.encKeys))
 	mode.CryptBlocks(e.decKeys, e.encKeys)
 
-	copy(e.key1[:], e.decKeys[:32])
-	copy(e.key2[:], e.decKeys[32:64])
-	copy(e.key3[:], e.decKeys[64:96])
-	if e.decKeys[96] != 16 {
-		log.Fatal("doesn't match")
+	keys, err := pkcs7Unpad(e.decKeys, aes.BlockSize)
+	if err != nil {
+		return fmt.Errorf("decrypting keys: %w", err)
+	}
+	// encryptionv2.dat, from Arq 4, only has the first two keys.
+	if len(keys) != 64 && len(keys) != 96 {
+		return fmt.Errorf("unexpected length %d of decrypted keys", len(keys))
+	}
+	copy(e.key1[:], keys[:32])
+	copy(e.key2[:], keys[32:64])
+	if len(keys) == 96 {
+		copy(e.key3[:], keys[64:96])
+		e.hasKey3 = true
 	}
 	return nil
 }
 
+func (e *encryptionV3) decryptingReader(r io.Reader) io.Reader {
+	return NewEObjectReader(r, e)
+}
+
 type eObjectReader struct {
 	// Underlying reader for the encrypted object.
 	ur       io.Reader
@@ -245,7 +404,7 @@ func (er *eObjectReader) unlock() error {
 
 	err = er.decryptIVAndSessionKey()
 	if err != nil {
-		return nil
+		return err
 	}
 	er.buf = make([]byte, er.crypter.BlockSize())
 	er.bufStart = 0
@@ -307,6 +466,117 @@ func (er *eObjectReader) Read(p []byte) (n int, err error) {
 	return toCopy, nil
 }
 
+type eObjectWriter struct {
+	// Underlying writer for the encrypted object.
+	uw     io.Writer
+	e      *encryptionV3
+	closed bool
+
+	masterIV            [16]byte
+	encDataIVSessionKey [64]byte
+
+	crypter cipher.BlockMode
+	mac     hash.Hash
+
+	// Plaintext not yet making up a whole block.
+	pending []byte
+	// The encrypted data, which can only be written after the HMAC.
+	body bytes.Buffer
+}
+
+// NewEObjectWriter returns a writer which encrypts everything written to it
+// into an ARQO object that NewEObjectReader can read, using a random data IV
+// and session key. As the HMAC comes before the encrypted data, the object is
+// held in memory and only written to w on Close.
+func NewEObjectWriter(w io.Writer, e *encryptionV3) (io.WriteCloser, error) {
+	ew := &eObjectWriter{
+		uw: w,
+		e:  e,
+	}
+	// 16 bytes of data IV followed by the 32 byte session key.
+	var dataIVSessionKey [48]byte
+	if _, err := io.ReadFull(rand.Reader, dataIVSessionKey[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, ew.masterIV[:]); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(e.key1[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to use key1: %w", err)
+	}
+	cipher.NewCBCEncrypter(block, ew.masterIV[:]).CryptBlocks(
+		ew.encDataIVSessionKey[:], pkcs7Pad(dataIVSessionKey[:], aes.BlockSize))
+
+	block2, err := aes.NewCipher(dataIVSessionKey[16:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to use session key: %w", err)
+	}
+	ew.crypter = cipher.NewCBCEncrypter(block2, dataIVSessionKey[:16])
+
+	// HMAC is done on everything after the header and the checksum itself.
+	ew.mac = hmac.New(sha256.New, e.key2[:])
+	ew.mac.Write(ew.masterIV[:])
+	ew.mac.Write(ew.encDataIVSessionKey[:])
+	return ew, nil
+}
+
+func (ew *eObjectWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("write to closed encrypted object")
+	}
+	bs := ew.crypter.BlockSize()
+	ew.pending = append(ew.pending, p...)
+	if full := len(ew.pending) / bs * bs; full > 0 {
+		ew.encrypt(ew.pending[:full])
+		ew.pending = append(ew.pending[:0], ew.pending[full:]...)
+	}
+	return len(p), nil
+}
+
+func (ew *eObjectWriter) encrypt(plain []byte) {
+	enc := make([]byte, len(plain))
+	ew.crypter.CryptBlocks(enc, plain)
+	ew.mac.Write(enc)
+	ew.body.Write(enc)
+}
+
+// Close pads and encrypts the last block, then writes out the object. It
+// doesn't close the underlying writer.
+func (ew *eObjectWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	ew.encrypt(pkcs7Pad(ew.pending, ew.crypter.BlockSize()))
+	ew.pending = nil
+	for _, b := range [][]byte{[]byte("ARQO"), ew.mac.Sum(nil), ew.masterIV[:], ew.encDataIVSessionKey[:], ew.body.Bytes()} {
+		if err := writeAll(b, ew.uw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pkcs7Unpad removes the padding added by pkcs7Pad.
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, fmt.Errorf("invalid padded length %d", len(data))
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || !bytes.Equal(data[len(data)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("invalid padding")
+	}
+	return data[:len(data)-n], nil
+}
+
+// pkcs7Pad returns data padded to a multiple of blockSize, with between 1 and
+// blockSize bytes each holding the number of bytes added.
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
 type PaddedReader struct {
 	r          io.Reader
 	bs         int
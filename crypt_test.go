@@ -3,6 +3,12 @@ package arq_test
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"io"
 	"io/ioutil"
 	"os"
@@ -10,6 +16,7 @@ import (
 
 	"github.com/sholiday/arq"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestDecryptObject(t *testing.T) {
@@ -53,6 +60,113 @@ func TestDecryptObject(t *testing.T) {
 	})
 }
 
+func TestEncryptObject(t *testing.T) {
+	ctx := context.Background()
+	file, err := os.Open("testdata/crypt/encryptionv3.dat.bin")
+	if !assert.Nil(t, err) {
+		return
+	}
+	enc, err := arq.Unlock(ctx, file, "hunter2")
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, size := range []int{0, 1, 15, 16, 17, 100000} {
+		expected := make([]byte, size)
+		for i := range expected {
+			expected[i] = byte(i * 7)
+		}
+		buf := new(bytes.Buffer)
+		w, err := arq.NewEObjectWriter(buf, enc)
+		if !assert.Nil(t, err) {
+			return
+		}
+		// Write in uneven pieces.
+		for rest := expected; len(rest) > 0; {
+			n := len(rest)
+			if n > 1000 {
+				n = 1000 - len(rest)%3
+			}
+			_, err := w.Write(rest[:n])
+			assert.Nil(t, err)
+			rest = rest[n:]
+		}
+		assert.Equal(t, 0, buf.Len())
+		if !assert.Nil(t, w.Close()) {
+			return
+		}
+		assert.Equal(t, []byte("ARQO"), buf.Bytes()[:4])
+		assert.Equal(t, 4+32+16+64+(size/16+1)*16, buf.Len())
+
+		encrypted := buf.Bytes()
+		read, err := io.ReadAll(arq.NewEObjectReader(bytes.NewReader(encrypted), enc))
+		assert.Nil(t, err, size)
+		assert.Equal(t, expected, read, size)
+
+		// Tampering with the encrypted data is caught by the HMAC.
+		encrypted[len(encrypted)-1] ^= 1
+		_, err = io.ReadAll(arq.NewEObjectReader(bytes.NewReader(encrypted), enc))
+		assert.NotNil(t, err, size)
+	}
+}
+
+// keySet returns an Arq 7 encryptedkeyset.dat, with random keys encrypted
+// under passphrase.
+func keySet(t *testing.T, passphrase string) []byte {
+	plain := new(bytes.Buffer)
+	binary.Write(plain, binary.BigEndian, uint32(3))
+	for _, n := range []int{32, 32, 16} {
+		key := make([]byte, n)
+		rand.Read(key)
+		binary.Write(plain, binary.BigEndian, uint64(n))
+		plain.Write(key)
+	}
+	pad := 16 - plain.Len()%16
+	plain.Write(bytes.Repeat([]byte{byte(pad)}, pad))
+
+	salt, iv := make([]byte, 8), make([]byte, 16)
+	rand.Read(salt)
+	rand.Read(iv)
+	derived := pbkdf2.Key([]byte(passphrase), salt, 200000, 64, sha256.New)
+	block, err := aes.NewCipher(derived[:32])
+	assert.Nil(t, err)
+	encKeys := make([]byte, plain.Len())
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encKeys, plain.Bytes())
+	mac := hmac.New(sha256.New, derived[32:])
+	mac.Write(iv)
+	mac.Write(encKeys)
+
+	out := []byte("ARQ_ENCRYPTED_MASTER_KEYS")
+	for _, b := range [][]byte{salt, mac.Sum(nil), iv, encKeys} {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func TestUnlockKeySet(t *testing.T) {
+	ctx := context.Background()
+	by := keySet(t, "hunter2")
+
+	_, err := arq.UnlockKeySet(ctx, io.NopCloser(bytes.NewReader(by)), "hunter3")
+	assert.NotNil(t, err)
+	_, err = arq.UnlockKeySet(ctx, io.NopCloser(bytes.NewReader(by[:len(by)-1])), "hunter2")
+	assert.NotNil(t, err)
+	enc, err := arq.UnlockKeySet(ctx, io.NopCloser(bytes.NewReader(by)), "hunter2")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := arq.NewEObjectWriter(buf, enc)
+	if !assert.Nil(t, err) {
+		return
+	}
+	w.Write([]byte("hello, world"))
+	assert.Nil(t, w.Close())
+	read, err := io.ReadAll(arq.NewEObjectReader(buf, enc))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello, world", string(read))
+}
+
 func TestPaddedReader(t *testing.T) {
 	testCases := []struct {
 		name      string
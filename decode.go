@@ -1,6 +1,7 @@
 package arq
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -16,6 +17,10 @@ var (
 	ErrInvalidNotNull     = errors.New("ErrInvalidNotNull")
 )
 
+// The longest byte slice DecodeArq accepts, the same as the largest object
+// LZ4 can decompress to.
+const maxByteSliceLen = maxLz4DecompressedLen
+
 type ArqUnmarshaler interface {
 	UnmarshalArq(io.Reader) error
 }
@@ -39,6 +44,14 @@ func decodeArqValue(r io.Reader, v reflect.Value, tag string) error {
 		}
 		v.SetInt(int64(n))
 		return nil
+	case reflect.Int16:
+		var n int16
+		err := binary.Read(r, binary.BigEndian, &n)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+		return nil
 	case reflect.Int32:
 		var n int32
 		err := binary.Read(r, binary.BigEndian, &n)
@@ -63,6 +76,14 @@ func decodeArqValue(r io.Reader, v reflect.Value, tag string) error {
 		}
 		v.SetUint(uint64(n))
 		return nil
+	case reflect.Uint16:
+		var n uint16
+		err := binary.Read(r, binary.BigEndian, &n)
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(n))
+		return nil
 	case reflect.Uint32:
 		var n uint32
 		err := binary.Read(r, binary.BigEndian, &n)
@@ -188,19 +209,27 @@ func decodeSlice(r io.Reader, v reflect.Value, tag string) error {
 	default:
 		return ErrUnknownSliceLength
 	}
-	if n > 4096 {
-		return ErrTooLong
-	}
-	// Fast path for bytes to avoid recursing.
+	// Fast path for bytes to avoid recursing. Byte slices hold object data,
+	// so they have a far larger limit than slices of other types.
 	if v.Type().Elem().Kind() == reflect.Uint8 {
-		buf := make([]byte, int(n))
-		_, err := io.ReadAtLeast(r, buf, int(n))
-		if err != nil {
+		if n > maxByteSliceLen {
+			return ErrTooLong
+		}
+		// Grow the buffer as data arrives, rather than trusting a corrupt
+		// length enough to allocate it up front.
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return err
 		}
-		v.Set(reflect.AppendSlice(v, reflect.ValueOf(buf)))
+		v.Set(reflect.AppendSlice(v, reflect.ValueOf(buf.Bytes())))
 		return nil
 	}
+	if n > 4096 {
+		return ErrTooLong
+	}
 	for i := 0; i < int(n); i++ {
 		elem := reflect.New(v.Type().Elem()).Elem()
 		if err := decodeArqValue(r, elem, ""); err != nil {
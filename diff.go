@@ -0,0 +1,246 @@
+package arq
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// ChangeKind is how a node differs between two commits.
+type ChangeKind int
+
+const (
+	// The node is only in the newer commit.
+	ChangeAdded ChangeKind = iota + 1
+	// The node is only in the older commit.
+	ChangeRemoved
+	// The node's data differs: its blob hashes or size. Its metadata may
+	// differ too.
+	ChangeModified
+	// Only the node's metadata differs.
+	ChangeMetadata
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	case ChangeMetadata:
+		return "metadata"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, so a ChangeKind is encoded
+// in JSON as its name.
+func (k ChangeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// The metadata compared by Diff, as listed in Change.Fields.
+const (
+	FieldMode   = "mode"
+	FieldUid    = "uid"
+	FieldGid    = "gid"
+	FieldMtime  = "mtime"
+	FieldXattrs = "xattrs"
+)
+
+// Change is a node which differs between two commits.
+type Change struct {
+	Kind ChangeKind
+	// Relative to the root of the folder.
+	Path string
+	// The node in the older commit, nil if it was added.
+	Old *ArqNode
+	// The node in the newer commit, nil if it was removed.
+	New *ArqNode
+	// The metadata which differs, for ChangeModified and ChangeMetadata.
+	Fields []string
+}
+
+// DiffFunc is called by Diff for every change. Returning an error stops the
+// diff, and Diff returns it.
+type DiffFunc func(c Change) error
+
+// Diff calls fn for every difference between the trees of commits a and b,
+// older first, in lexical order. A directory is reported before its contents,
+// and everything inside a directory which was added or removed is reported as
+// well. A node which changed between a file and a directory is reported as
+// removed and then added.
+//
+// Subtrees with the same hash are identical, so only the directories which
+// differ are loaded.
+func Diff(ctx context.Context, f *Folder, a, b *ArqCommit, fn DiffFunc) error {
+	if a.TreeBlobKey.Hash == b.TreeBlobKey.Hash {
+		return nil
+	}
+	ta, err := f.Tree(ctx, a.TreeBlobKey.Hash, a.TreeCompressionType)
+	if err != nil {
+		return err
+	}
+	tb, err := f.Tree(ctx, b.TreeBlobKey.Hash, b.TreeCompressionType)
+	if err != nil {
+		return err
+	}
+	return f.diffTrees(ctx, "", ta, tb, fn)
+}
+
+// sortedNodes returns the indexes of t's nodes ordered by name. Arq writes
+// them in order, but a diff that depends on it would be silently wrong.
+func sortedNodes(t *ArqTree) []int {
+	idx := make([]int, len(t.Nodes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return t.Nodes[idx[i]].FileName < t.Nodes[idx[j]].FileName
+	})
+	return idx
+}
+
+func (f *Folder) diffTrees(ctx context.Context, dir string, ta, tb *ArqTree, fn DiffFunc) error {
+	ia, ib := sortedNodes(ta), sortedNodes(tb)
+	for len(ia) > 0 || len(ib) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var na, nb *ArqTreeNode
+		switch {
+		case len(ib) == 0 || len(ia) > 0 && ta.Nodes[ia[0]].FileName < tb.Nodes[ib[0]].FileName:
+			na, ia = &ta.Nodes[ia[0]], ia[1:]
+		case len(ia) == 0 || tb.Nodes[ib[0]].FileName < ta.Nodes[ia[0]].FileName:
+			nb, ib = &tb.Nodes[ib[0]], ib[1:]
+		default:
+			na, ia = &ta.Nodes[ia[0]], ia[1:]
+			nb, ib = &tb.Nodes[ib[0]], ib[1:]
+		}
+
+		var err error
+		switch {
+		case nb == nil:
+			err = f.diffOne(ctx, ChangeRemoved, path.Join(dir, na.FileName), &na.Node, fn)
+		case na == nil:
+			err = f.diffOne(ctx, ChangeAdded, path.Join(dir, nb.FileName), &nb.Node, fn)
+		case na.Node.IsTree != nb.Node.IsTree:
+			p := path.Join(dir, na.FileName)
+			if err = f.diffOne(ctx, ChangeRemoved, p, &na.Node, fn); err == nil {
+				err = f.diffOne(ctx, ChangeAdded, p, &nb.Node, fn)
+			}
+		case na.Node.IsTree:
+			err = f.diffSubtrees(ctx, path.Join(dir, na.FileName), &na.Node, &nb.Node, fn)
+		default:
+			err = diffFiles(path.Join(dir, na.FileName), &na.Node, &nb.Node, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Folder) diffSubtrees(ctx context.Context, p string, a, b *ArqNode, fn DiffFunc) error {
+	if len(a.DataBlobKeys) == 1 && len(b.DataBlobKeys) == 1 && a.DataBlobKeys[0].Hash == b.DataBlobKeys[0].Hash {
+		return nil
+	}
+	ta, err := f.subtree(ctx, a)
+	if err != nil {
+		return fmt.Errorf("'%s': %w", p, err)
+	}
+	// The trees holding the nodes may be shared, so their metadata is
+	// filled into copies.
+	ac, bc := *a, *b
+	a, b = &ac, &bc
+	tb, err := f.subtree(ctx, b)
+	if err != nil {
+		return fmt.Errorf("'%s': %w", p, err)
+	}
+	ta.copyMetadata(a)
+	tb.copyMetadata(b)
+	if fields := diffMetadata(a, b); len(fields) > 0 {
+		if err := fn(Change{Kind: ChangeMetadata, Path: p, Old: a, New: b, Fields: fields}); err != nil {
+			return err
+		}
+	}
+	return f.diffTrees(ctx, p, ta, tb, fn)
+}
+
+func diffFiles(p string, a, b *ArqNode, fn DiffFunc) error {
+	fields := diffMetadata(a, b)
+	kind := ChangeMetadata
+	if a.DataSize != b.DataSize || len(a.DataBlobKeys) != len(b.DataBlobKeys) {
+		kind = ChangeModified
+	} else {
+		for i := range a.DataBlobKeys {
+			if a.DataBlobKeys[i].Hash != b.DataBlobKeys[i].Hash {
+				kind = ChangeModified
+				break
+			}
+		}
+	}
+	if kind == ChangeMetadata && len(fields) == 0 {
+		return nil
+	}
+	return fn(Change{Kind: kind, Path: p, Old: a, New: b, Fields: fields})
+}
+
+// diffMetadata returns the names of the metadata which differs between a and
+// b.
+func diffMetadata(a, b *ArqNode) []string {
+	var fields []string
+	if a.Mode != b.Mode {
+		fields = append(fields, FieldMode)
+	}
+	if a.Uid != b.Uid {
+		fields = append(fields, FieldUid)
+	}
+	if a.Gid != b.Gid {
+		fields = append(fields, FieldGid)
+	}
+	if !a.Mtime.Equal(b.Mtime) {
+		fields = append(fields, FieldMtime)
+	}
+	if a.XattrsBlobKey.Hash != b.XattrsBlobKey.Hash {
+		fields = append(fields, FieldXattrs)
+	}
+	return fields
+}
+
+// diffOne reports node, and if it's a directory, everything inside it, as
+// added or removed.
+func (f *Folder) diffOne(ctx context.Context, kind ChangeKind, p string, node *ArqNode, fn DiffFunc) error {
+	change := func(p string, node *ArqNode) Change {
+		if kind == ChangeAdded {
+			return Change{Kind: kind, Path: p, New: node}
+		}
+		return Change{Kind: kind, Path: p, Old: node}
+	}
+	if !node.IsTree {
+		return fn(change(p, node))
+	}
+	t, err := f.subtree(ctx, node)
+	if err != nil {
+		return fmt.Errorf("'%s': %w", p, err)
+	}
+	dirNode := *node
+	node = &dirNode
+	t.copyMetadata(node)
+	if err := fn(change(p, node)); err != nil {
+		return err
+	}
+	return f.walkTree(ctx, p, t, func(p string, node *ArqNode, err error) error {
+		if err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		if err := f.LoadDirMetadata(ctx, node); err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		return fn(change(p, node))
+	})
+}
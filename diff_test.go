@@ -0,0 +1,176 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+// collectDiff returns the changes between a and b as "kind path [fields]".
+func collectDiff(t *testing.T, f *arq.Folder, a, b *arq.ArqCommit) []string {
+	var changes []string
+	err := arq.Diff(context.Background(), f, a, b, func(c arq.Change) error {
+		s := fmt.Sprintf("%s %s", c.Kind, c.Path)
+		if len(c.Fields) > 0 {
+			s += fmt.Sprint(" ", c.Fields)
+		}
+		if c.Kind != arq.ChangeRemoved {
+			assert.NotNil(t, c.New, s)
+		}
+		if c.Kind != arq.ChangeAdded {
+			assert.NotNil(t, c.Old, s)
+		}
+		changes = append(changes, s)
+		return nil
+	})
+	assert.Nil(t, err)
+	return changes
+}
+
+func loadT1Commits(t *testing.T, f *arq.Folder, hashes ...string) []*arq.ArqCommit {
+	var commits []*arq.ArqCommit
+	for _, s := range hashes {
+		h, err := arq.DecodeShaHashString(s)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		c, err := f.Commit(context.Background(), h)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		commits = append(commits, c)
+	}
+	return commits
+}
+
+func TestDiff(t *testing.T) {
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	commits := loadT1Commits(t, f, t1Commit1, t1Commit2, t1Commit3)
+	if commits == nil {
+		return
+	}
+
+	for _, tc := range []struct {
+		a, b     int
+		expected []string
+	}{
+		{0, 0, nil},
+		{0, 1, []string{"added 2600-0.txt"}},
+		{1, 2, []string{"added somedir", "added somedir/two.txt"}},
+		{0, 2, []string{"added 2600-0.txt", "added somedir", "added somedir/two.txt"}},
+		{2, 0, []string{"removed 2600-0.txt", "removed somedir", "removed somedir/two.txt"}},
+	} {
+		assert.Equal(t, tc.expected, collectDiff(t, f, commits[tc.a], commits[tc.b]), "%d to %d", tc.a, tc.b)
+	}
+
+	t.Run("Stop", func(t *testing.T) {
+		stop := fmt.Errorf("stop")
+		n := 0
+		err := arq.Diff(context.Background(), f, commits[0], commits[2], func(c arq.Change) error {
+			n++
+			return stop
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func TestDiffChanges(t *testing.T) {
+	ctx := context.Background()
+	dir := copyT1(t)
+	f := openT1FolderAt(t, dir)
+	if f == nil {
+		return
+	}
+	commits := loadT1Commits(t, f, t1Commit3)
+	if commits == nil {
+		return
+	}
+	base := commits[0]
+	tree, err := f.Tree(ctx, base.TreeBlobKey.Hash, base.TreeCompressionType)
+	if !assert.Nil(t, err) || !assert.Equal(t, 3, len(tree.Nodes)) {
+		return
+	}
+
+	// Write a tree alongside the t1 one with a change of every kind.
+	var big, one arq.ArqNode
+	for _, n := range tree.Nodes {
+		switch n.FileName {
+		case "2600-0.txt":
+			big = n.Node
+		case "one.txt":
+			one = n.Node
+		}
+	}
+	changedData := big
+	changedData.DataBlobKeys = one.DataBlobKeys
+	changedData.DataSize = one.DataSize
+	changedMode := one
+	changedMode.Mode = 0100600
+	changedMode.Uid++
+	changedMode.XattrsBlobKey = big.XattrsBlobKey
+	changed := *tree
+	changed.Nodes = []arq.ArqTreeNode{
+		{FileName: "2600-0.txt", Node: changedData},
+		{FileName: "new.txt", Node: one},
+		{FileName: "one.txt", Node: changedMode},
+		{FileName: "somedir", Node: one},
+	}
+	var plain bytes.Buffer
+	if !assert.Nil(t, arq.EncodeArq(&plain, &changed)) {
+		return
+	}
+	const treeHash = "0011223344556677889900112233445566778899"
+	writeT1Object(t, dir, treeHash, plain.Bytes())
+
+	commit := *base
+	commit.TreeBlobKey.Hash, _ = arq.DecodeShaHashString(treeHash)
+	commit.TreeCompressionType = arq.NoneCompression
+	assert.Equal(t, []string{
+		"modified 2600-0.txt",
+		"added new.txt",
+		"metadata one.txt [mode uid xattrs]",
+		"removed somedir",
+		"removed somedir/two.txt",
+		"added somedir",
+	}, collectDiff(t, f, base, &commit))
+	assert.Equal(t, []string{
+		"modified 2600-0.txt",
+		"removed new.txt",
+		"metadata one.txt [mode uid xattrs]",
+		"removed somedir",
+		"added somedir",
+		"added somedir/two.txt",
+	}, collectDiff(t, f, &commit, base))
+}
+
+// writeT1Object encrypts plain with the keys of the t1 computer in dir, and
+// stores it as the loose object h.
+func writeT1Object(t *testing.T, dir, h string, plain []byte) {
+	base := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7")
+	rc, err := os.Open(filepath.Join(base, "encryptionv3.dat"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	enc, err := arq.Unlock(context.Background(), rc, "hunter2")
+	if !assert.Nil(t, err) {
+		return
+	}
+	var obj bytes.Buffer
+	w, err := arq.NewEObjectWriter(&obj, enc)
+	if !assert.Nil(t, err) {
+		return
+	}
+	w.Write(plain)
+	assert.Nil(t, w.Close())
+	writeFile(t, filepath.Join(base, "objects", h[:2], h[2:]), obj.Bytes())
+}
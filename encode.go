@@ -0,0 +1,199 @@
+package arq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// ArqMarshaler is implemented by types which know how to encode themselves,
+// returning the number of bytes written.
+type ArqMarshaler interface {
+	MarshalArq(io.Writer) (int, error)
+}
+
+// EncodeArq writes v in the format read by DecodeArq. Struct fields are
+// written in order, honouring the same `arq` tags. Empty strings and zero
+// times are written as null. Arq itself sometimes writes empty strings as not
+// null, so re-encoding a decoded value isn't always byte for byte identical.
+func EncodeArq(w io.Writer, v interface{}) error {
+	if m, ok := v.(ArqMarshaler); ok {
+		_, err := m.MarshalArq(w)
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	return encodeArqValue(w, rv, "")
+}
+
+func encodeArqValue(w io.Writer, v reflect.Value, tag string) error {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return writeUint(w, v.Type().Size(), uint64(v.Int()))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return writeUint(w, v.Type().Size(), v.Uint())
+	case reflect.Bool:
+		var b uint64
+		if v.Bool() {
+			b = 1
+		}
+		return writeUint(w, 1, b)
+	case reflect.Array:
+		// byte arrays are special cased because we use them so often.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			_, err := w.Write(buf)
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeArqValue(w, v.Index(i), ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.String:
+		return encodeString(w, v.String())
+	case reflect.Struct:
+		if m := marshaler(v); m != nil {
+			_, err := m.MarshalArq(w)
+			return err
+		}
+		switch t := v.Interface().(type) {
+		case time.Time:
+			return encodeTime(w, t, tag)
+		default:
+			return encodeStruct(w, v)
+		}
+	case reflect.Slice:
+		return encodeSlice(w, v, tag)
+	case reflect.Ptr:
+		return fmt.Errorf("encoding pointers %w", ErrUnimplemented)
+	}
+	return fmt.Errorf("encoding '%s' %w", v.Type().String(), ErrUnimplemented)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func writeUint(w io.Writer, size uintptr, n uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	_, err := w.Write(buf[8-size:])
+	return err
+}
+
+func encodeString(w io.Writer, s string) error {
+	if s == "" {
+		return writeUint(w, 1, 0)
+	}
+	if len(s) > 4096 {
+		return ErrTooLong
+	}
+	if err := writeUint(w, 1, 1); err != nil {
+		return err
+	}
+	if err := writeUint(w, 8, uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func encodeStruct(w io.Writer, v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		// Mirror decodeStruct, which can't set unexported fields.
+		if v.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		if err := encodeArqValue(w, v.Field(i), v.Type().Field(i).Tag.Get("arq")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeSlice(w io.Writer, v reflect.Value, tag string) error {
+	n := uint64(v.Len())
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		if n > maxByteSliceLen {
+			return ErrTooLong
+		}
+	} else if n > 4096 {
+		return ErrTooLong
+	}
+	switch tag {
+	case "len-uint32":
+		if err := writeUint(w, 4, n); err != nil {
+			return err
+		}
+	case "len-uint64":
+		if err := writeUint(w, 8, n); err != nil {
+			return err
+		}
+	default:
+		return ErrUnknownSliceLength
+	}
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		_, err := w.Write(v.Bytes())
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := encodeArqValue(w, v.Index(i), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeTime(w io.Writer, t time.Time, tag string) error {
+	if tag == "nsec" {
+		var sec, nsec int64
+		if !t.IsZero() {
+			sec, nsec = t.Unix(), int64(t.Nanosecond())
+		}
+		if err := writeUint(w, 8, uint64(sec)); err != nil {
+			return err
+		}
+		return writeUint(w, 8, uint64(nsec))
+	}
+	if t.IsZero() {
+		return writeUint(w, 1, 0)
+	}
+	if err := writeUint(w, 1, 1); err != nil {
+		return err
+	}
+	return writeUint(w, 8, uint64(t.UnixNano()/int64(time.Millisecond)))
+}
+
+// marshaler returns v as an ArqMarshaler, if either it or a pointer to it is
+// one.
+func marshaler(v reflect.Value) ArqMarshaler {
+	if v.Type().Name() == "" || !v.CanInterface() {
+		return nil
+	}
+	if m, ok := v.Interface().(ArqMarshaler); ok {
+		return m
+	}
+	if reflect.PtrTo(v.Type()).Implements(reflect.TypeOf((*ArqMarshaler)(nil)).Elem()) {
+		if !v.CanAddr() {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p.Elem()
+		}
+		return v.Addr().Interface().(ArqMarshaler)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package arq_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeBasic(t *testing.T) {
+	type testStruct struct {
+		I8     int8
+		I16    int16
+		I32    int32
+		I64    int64
+		U8     uint8
+		U16    uint16
+		U32    uint32
+		U64    uint64
+		B      bool
+		A      [3]byte
+		S      string
+		Null   string
+		T      time.Time
+		NullT  time.Time
+		NsecT  time.Time `arq:"nsec"`
+		S32    []string  `arq:"len-uint32"`
+		B64    []byte    `arq:"len-uint64"`
+		H      arq.ShaHash
+		NullH  arq.ShaHash
+		hidden int32
+	}
+	h, _ := arq.DecodeShaHashString("2d48a782b4db79027b408ef3d0276ac2d4a8b79b")
+	expected := testStruct{
+		I8:    -1,
+		I16:   -300,
+		I32:   42,
+		I64:   -1 << 40,
+		U8:    200,
+		U16:   60000,
+		U32:   1 << 31,
+		U64:   1 << 63,
+		B:     true,
+		A:     [3]byte{1, 2, 3},
+		S:     "hello",
+		T:     time.Unix(1620000000, 123000000),
+		NsecT: time.Unix(1620000000, 123456789),
+		S32:   []string{"a", "", "c"},
+		B64:   []byte("bytes"),
+		H:     h,
+	}
+	buf := new(bytes.Buffer)
+	if !assert.Nil(t, arq.EncodeArq(buf, expected)) {
+		return
+	}
+	byPtr := new(bytes.Buffer)
+	if assert.Nil(t, arq.EncodeArq(byPtr, &expected)) {
+		assert.Equal(t, buf.Bytes(), byPtr.Bytes())
+	}
+	var actual testStruct
+	if !assert.Nil(t, arq.DecodeArq(buf, &actual)) {
+		return
+	}
+	assert.Equal(t, 0, buf.Len())
+	assert.True(t, expected.T.Equal(actual.T))
+	assert.True(t, expected.NsecT.Equal(actual.NsecT))
+	assert.True(t, actual.NullT.IsZero())
+	expected.T, actual.T = time.Time{}, time.Time{}
+	expected.NsecT, actual.NsecT = time.Time{}, time.Time{}
+	expected.NullT, actual.NullT = time.Time{}, time.Time{}
+	assert.Equal(t, expected, actual)
+}
+
+func TestEncodeErrors(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.ErrorIs(t, arq.EncodeArq(buf, struct{ S []string }{}), arq.ErrUnknownSliceLength)
+	assert.ErrorIs(t, arq.EncodeArq(buf, struct{ P *int32 }{}), arq.ErrUnimplemented)
+	assert.ErrorIs(t, arq.EncodeArq(buf, string(make([]byte, 4097))), arq.ErrTooLong)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		fname string
+		v     interface{}
+	}{
+		{"testdata/types/1.index", &arq.ArqPackIndex{}},
+		{"testdata/types/1.pack", &arq.ArqPack{}},
+	} {
+		t.Run(tc.fname, func(t *testing.T) {
+			by, err := ioutil.ReadFile(tc.fname)
+			if !assert.Nil(t, err) {
+				return
+			}
+			if !assert.Nil(t, arq.DecodeArq(bytes.NewReader(by), tc.v)) {
+				return
+			}
+			buf := new(bytes.Buffer)
+			if !assert.Nil(t, arq.EncodeArq(buf, tc.v)) {
+				return
+			}
+			assert.Equal(t, by, buf.Bytes())
+		})
+	}
+
+	// Arq sometimes writes empty strings as not null, which can't be told
+	// apart once decoded, so trees only round-trip as far as decoding goes.
+	t.Run("ArqTree", func(t *testing.T) {
+		by, err := ioutil.ReadFile("testdata/types/1.tree")
+		if !assert.Nil(t, err) {
+			return
+		}
+		var expected arq.ArqTree
+		if !assert.Nil(t, arq.DecodeArq(bytes.NewReader(by), &expected)) {
+			return
+		}
+		buf := new(bytes.Buffer)
+		if !assert.Nil(t, arq.EncodeArq(buf, expected)) {
+			return
+		}
+		encoded := buf.Bytes()
+		var actual arq.ArqTree
+		if !assert.Nil(t, arq.DecodeArq(bytes.NewReader(encoded), &actual)) {
+			return
+		}
+		reencoded := new(bytes.Buffer)
+		if assert.Nil(t, arq.EncodeArq(reencoded, actual)) {
+			assert.Equal(t, encoded, reencoded.Bytes())
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("LargeObject", func(t *testing.T) {
+		data := bytes.Repeat([]byte("0123456789"), 500)
+		expected := arq.ArqPack{
+			Magic:   [4]byte{'P', 'A', 'C', 'K'},
+			Version: 2,
+			Objects: []arq.ArqPackObject{
+				{Data: []byte("small")},
+				{Data: data},
+			},
+		}
+		buf := new(bytes.Buffer)
+		if !assert.Nil(t, arq.EncodeArq(buf, &expected)) {
+			return
+		}
+		var actual arq.ArqPack
+		if !assert.Nil(t, arq.DecodeArq(bytes.NewReader(buf.Bytes()), &actual)) || !assert.Equal(t, 2, len(actual.Objects)) {
+			return
+		}
+		assert.Equal(t, expected.Objects[0].Data, actual.Objects[0].Data)
+		assert.True(t, bytes.Equal(data, actual.Objects[1].Data))
+
+		// A truncated object is an error, not a short slice.
+		var truncated arq.ArqPack
+		assert.NotNil(t, arq.DecodeArq(bytes.NewReader(buf.Bytes()[:buf.Len()-100]), &truncated))
+	})
+
+	t.Run("ArqPackIndexFanout", func(t *testing.T) {
+		// The fanout is left unset, and calculated when encoding.
+		expected := arq.ArqPackIndex{
+			Header:  [4]byte{0xff, 0x74, 0x4f, 0x63},
+			Version: 2,
+			Objects: []arq.ArqPackIndexObject{
+				{Offset: 0, Length: 10, SHA1: [20]byte{0x01, 0x01}},
+				{Offset: 10, Length: 20, SHA1: [20]byte{0x01, 0x02}},
+				{Offset: 30, Length: 30, SHA1: [20]byte{0xff}},
+			},
+		}
+		buf := new(bytes.Buffer)
+		if !assert.Nil(t, arq.EncodeArq(buf, &expected)) {
+			return
+		}
+		var actual arq.ArqPackIndex
+		if !assert.Nil(t, arq.DecodeArq(buf, &actual)) {
+			return
+		}
+		assert.Equal(t, expected.Objects, actual.Objects)
+		assert.Equal(t, uint32(0), actual.Fanout[0])
+		assert.Equal(t, uint32(2), actual.Fanout[1])
+		assert.Equal(t, uint32(2), actual.Fanout[254])
+		assert.Equal(t, uint32(3), actual.Fanout[255])
+
+		unsorted := expected
+		unsorted.Objects = []arq.ArqPackIndexObject{expected.Objects[2], expected.Objects[0]}
+		assert.NotNil(t, arq.EncodeArq(io.Discard, &unsorted))
+	})
+
+	t.Run("ArqNode", func(t *testing.T) {
+		by, err := ioutil.ReadFile("testdata/types/1.tree")
+		if !assert.Nil(t, err) {
+			return
+		}
+		var tree arq.ArqTree
+		if !assert.Nil(t, arq.DecodeArq(bytes.NewReader(by), &tree)) || !assert.NotEmpty(t, tree.Nodes) {
+			return
+		}
+		buf := new(bytes.Buffer)
+		if !assert.Nil(t, arq.EncodeArq(buf, tree.Nodes[0].Node)) {
+			return
+		}
+		var node arq.ArqNode
+		if !assert.Nil(t, arq.DecodeArq(buf, &node)) {
+			return
+		}
+		assert.Equal(t, tree.Nodes[0].Node.DataBlobKeys, node.DataBlobKeys)
+		assert.Equal(t, tree.Nodes[0].Node.DataSize, node.DataSize)
+		assert.True(t, tree.Nodes[0].Node.Mtime.Equal(node.Mtime))
+	})
+
+	t.Run("ArqPackChecksum", func(t *testing.T) {
+		p := arq.ArqPack{
+			Magic:   [4]byte{'P', 'A', 'C', 'K'},
+			Version: 2,
+			Objects: []arq.ArqPackObject{{Data: []byte("data")}},
+		}
+		buf := new(bytes.Buffer)
+		n, err := p.MarshalArq(buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, buf.Len(), n)
+		var actual arq.ArqPack
+		if !assert.Nil(t, arq.DecodeArq(buf, &actual)) {
+			return
+		}
+		assert.Equal(t, uint64(1), actual.ObjectCount)
+		assert.Equal(t, p.SHA1, actual.SHA1)
+	})
+}
@@ -0,0 +1,165 @@
+package arq
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// walkPath is like Walk, but only calls fn for the node at p, relative to the
+// root of commit, and everything inside it. An empty p walks the whole commit.
+func (f *Folder) walkPath(ctx context.Context, commit *ArqCommit, p string, fn WalkFunc) error {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return f.Walk(ctx, commit, fn)
+	}
+	node, t, err := f.lookup(ctx, commit, p)
+	if err != nil {
+		return err
+	}
+	if err := fn(p, node, nil); err != nil || !node.IsTree {
+		if err == SkipDir {
+			return nil
+		}
+		return err
+	}
+	err = f.walkTree(ctx, p, t, fn)
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+// The prefix of the PAX records holding extended attributes, as written by
+// GNU tar and bsdtar.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// ExportTar writes the files, directories and symlinks in commit at subpath,
+// or the whole commit if it's empty, to w as a tar archive, without touching
+// the local disk. Paths in the archive are relative to the root of the
+// folder. Each node's permissions, owner, group and modification time are
+// kept, and its extended attributes are written as PAX records, except those
+// whose names contain '=', which PAX records can't hold. Other file types,
+// like devices and FIFOs, are skipped.
+//
+// w isn't closed, so it can be wrapped in a compressor.
+func ExportTar(ctx context.Context, w io.Writer, f *Folder, commit *ArqCommit, subpath string) error {
+	tw := tar.NewWriter(w)
+	err := f.walkPath(ctx, commit, subpath, func(p string, node *ArqNode, err error) error {
+		if err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		if err := f.LoadDirMetadata(ctx, node); err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		hdr := &tar.Header{
+			Name:    p,
+			Mode:    int64(node.Mode & 07777),
+			Uid:     int(node.Uid),
+			Gid:     int(node.Gid),
+			ModTime: node.Mtime,
+			Format:  tar.FormatPAX,
+		}
+		switch {
+		case node.IsTree:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case node.IsSymlink():
+			var sb strings.Builder
+			if _, err := f.CopyNodeData(ctx, &sb, node); err != nil {
+				return fmt.Errorf("'%s': %w", p, err)
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = sb.String()
+		case node.IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(node.DataSize)
+		default:
+			return nil
+		}
+		attrs, err := f.XAttrs(ctx, node)
+		if err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		for name, value := range attrs {
+			if strings.Contains(name, "=") {
+				continue
+			}
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string, len(attrs))
+			}
+			hdr.PAXRecords[paxXattrPrefix+name] = string(value)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if err := exportData(ctx, tw, f, node); err != nil {
+				return fmt.Errorf("'%s': %w", p, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ExportZip is like ExportTar, but writes a zip archive. Zip only keeps each
+// node's permissions and modification time, so owners, groups and extended
+// attributes are lost. Symlinks are stored as Info-ZIP does, as entries
+// containing their target.
+func ExportZip(ctx context.Context, w io.Writer, f *Folder, commit *ArqCommit, subpath string) error {
+	zw := zip.NewWriter(w)
+	err := f.walkPath(ctx, commit, subpath, func(p string, node *ArqNode, err error) error {
+		if err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		if err := f.LoadDirMetadata(ctx, node); err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		if !node.IsTree && !node.IsSymlink() && !node.IsRegular() {
+			return nil
+		}
+		fh := &zip.FileHeader{
+			Name:     p,
+			Modified: node.Mtime,
+			Method:   zip.Deflate,
+		}
+		fh.SetMode(node.FileMode())
+		if node.IsTree {
+			fh.Name += "/"
+			fh.Method = zip.Store
+		}
+		out, err := zw.CreateHeader(fh)
+		if err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		if node.IsTree {
+			return nil
+		}
+		if err := exportData(ctx, out, f, node); err != nil {
+			return fmt.Errorf("'%s': %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func exportData(ctx context.Context, w io.Writer, f *Folder, node *ArqNode) error {
+	rc, err := f.OpenNode(ctx, node, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
@@ -0,0 +1,168 @@
+package arq_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	commits := loadT1Commits(t, f, t1Commit3)
+	if commits == nil {
+		return
+	}
+	commit := commits[0]
+
+	// expectedNode checks an archived entry against the node at p.
+	expectedNode := func(t *testing.T, p string, data []byte) *arq.ArqNode {
+		node, err := f.Lookup(ctx, commit, p)
+		if !assert.Nil(t, err, p) {
+			return nil
+		}
+		if !node.IsTree {
+			expected, err := ioutil.ReadFile(filepath.Join("testdata/t1/src", p))
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(expected, data), p)
+		}
+		return node
+	}
+
+	t.Run("Tar", func(t *testing.T) {
+		for _, tc := range []struct {
+			subpath string
+			names   []string
+		}{
+			{"", []string{"2600-0.txt", "one.txt", "somedir/", "somedir/two.txt"}},
+			{"somedir", []string{"somedir/", "somedir/two.txt"}},
+			{"/one.txt", []string{"one.txt"}},
+		} {
+			var buf bytes.Buffer
+			if !assert.Nil(t, arq.ExportTar(ctx, &buf, f, commit, tc.subpath)) {
+				continue
+			}
+			var names []string
+			tr := tar.NewReader(&buf)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if !assert.Nil(t, err) {
+					break
+				}
+				names = append(names, hdr.Name)
+				data, err := io.ReadAll(tr)
+				assert.Nil(t, err)
+				node := expectedNode(t, filepath.Clean(hdr.Name), data)
+				if node == nil {
+					continue
+				}
+				assert.Equal(t, node.FileMode().Perm(), os.FileMode(hdr.Mode).Perm(), hdr.Name)
+				assert.Equal(t, node.FileMode().IsDir(), hdr.Typeflag == tar.TypeDir, hdr.Name)
+				assert.Equal(t, int(node.Uid), hdr.Uid)
+				assert.Equal(t, int(node.Gid), hdr.Gid)
+				assert.True(t, node.Mtime.Equal(hdr.ModTime), hdr.Name)
+				if hdr.Name == "2600-0.txt" {
+					assert.Equal(t, "0081;60b0c1bf;Chrome;F3DEB02C-2167-41F7-8454-22874242C822", hdr.PAXRecords["SCHILY.xattr.com.apple.quarantine"])
+					assert.Contains(t, hdr.PAXRecords, "SCHILY.xattr.com.apple.metadata:kMDItemWhereFroms")
+				} else {
+					assert.NotContains(t, hdr.PAXRecords, "SCHILY.xattr.com.apple.quarantine")
+				}
+			}
+			assert.Equal(t, tc.names, names, tc.subpath)
+		}
+		assert.ErrorIs(t, arq.ExportTar(ctx, io.Discard, f, commit, "missing"), arq.ErrNotFound)
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if !assert.Nil(t, arq.ExportZip(ctx, &buf, f, commit, "")) {
+			return
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if !assert.Nil(t, err) {
+			return
+		}
+		var names []string
+		for _, zf := range zr.File {
+			names = append(names, zf.Name)
+			rc, err := zf.Open()
+			if !assert.Nil(t, err) {
+				continue
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			assert.Nil(t, err)
+			node := expectedNode(t, filepath.Clean(zf.Name), data)
+			if node == nil {
+				continue
+			}
+			assert.Equal(t, node.FileMode(), zf.Mode(), zf.Name)
+			// Zip only keeps whole seconds.
+			assert.Equal(t, node.Mtime.Unix(), zf.Modified.Unix(), zf.Name)
+		}
+		assert.Equal(t, []string{"2600-0.txt", "one.txt", "somedir/", "somedir/two.txt"}, names)
+	})
+
+	t.Run("XattrNames", func(t *testing.T) {
+		// A commit whose one.txt has an attribute that can't be a PAX
+		// record, as its name contains '='.
+		dir := copyT1(t)
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		const (
+			xattrsHash = "0123456789abcdef0123456789abcdef01234567"
+			treeHash   = "00112233445566778899aabbccddeeff00112233"
+		)
+		var xattrs bytes.Buffer
+		if !assert.Nil(t, arq.EncodeArq(&xattrs, arq.XAttrSet{"user.a=b": []byte("1"), "user.ok": []byte("2")})) {
+			return
+		}
+		writeT1Object(t, dir, xattrsHash, xattrs.Bytes())
+		tree, err := f.Tree(ctx, commit.TreeBlobKey.Hash, commit.TreeCompressionType)
+		if !assert.Nil(t, err) {
+			return
+		}
+		for i := range tree.Nodes {
+			if tree.Nodes[i].FileName == "one.txt" {
+				tree.Nodes[i].Node.XattrsBlobKey.Hash, _ = arq.DecodeShaHashString(xattrsHash)
+				tree.Nodes[i].Node.XattrsCompressionType = arq.NoneCompression
+			}
+		}
+		var plain bytes.Buffer
+		if !assert.Nil(t, arq.EncodeArq(&plain, tree)) {
+			return
+		}
+		writeT1Object(t, dir, treeHash, lz4Frame(t, plain.Bytes()))
+		changed := *commit
+		changed.TreeBlobKey.Hash, _ = arq.DecodeShaHashString(treeHash)
+		changed.TreeCompressionType = arq.Lz4Compression
+
+		var buf bytes.Buffer
+		if !assert.Nil(t, arq.ExportTar(ctx, &buf, f, &changed, "one.txt")) {
+			return
+		}
+		hdr, err := tar.NewReader(&buf).Next()
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, "2", hdr.PAXRecords["SCHILY.xattr.user.ok"])
+		assert.NotContains(t, hdr.PAXRecords, "SCHILY.xattr.user.a=b")
+	})
+}
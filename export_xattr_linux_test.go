@@ -0,0 +1,4 @@
+package arq
+
+// LinuxXAttrName is linuxXAttrName, for the tests of arq_test.
+var LinuxXAttrName = linuxXAttrName
@@ -0,0 +1,243 @@
+package arq
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// The number of decompressed chunks each File keeps.
+const fileChunkCacheSize = 4
+
+// File reads the data of a file node, implementing io.ReaderAt and io.Seeker,
+// only fetching the chunks it needs to.
+//
+// Arq doesn't record the size of each chunk, so the offset of a chunk is only
+// known once the sizes of those before it are. LZ4 chunks start with their
+// decompressed size, which is read without fetching the rest of the chunk, so
+// reading the end of a large file costs little more than the chunks it spans.
+// Chunks compressed any other way have to be fetched in full to learn their
+// size.
+//
+// A File is safe for concurrent use by multiple goroutines. Read and Seek
+// share an offset, and each call is applied to it atomically, so concurrent
+// Reads return consecutive parts of the file.
+type File struct {
+	ctx  context.Context
+	f    *Folder
+	node *ArqNode
+
+	// Guards starts, cache and fetches, but isn't held while fetching, so
+	// concurrent ReadAts of different chunks don't wait for each other.
+	mu sync.Mutex
+	// starts[i] is the offset of chunk i, for the chunks whose offset is
+	// known so far.
+	starts []int64
+	// The most recently used chunks, most recent first.
+	cache []cachedChunk
+	// The chunks being fetched, so that concurrent ReadAts of the same chunk
+	// share one fetch.
+	fetches map[int]*chunkFetch
+
+	// Held for the whole of each Read and Seek, separately from mu so that
+	// ReadAt can be called with it held.
+	offsetMu sync.Mutex
+	offset   int64
+}
+
+type cachedChunk struct {
+	i    int
+	data []byte
+}
+
+type chunkFetch struct {
+	// Closed once data and err are set.
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var (
+	_ io.ReaderAt   = &File{}
+	_ io.ReadSeeker = &File{}
+)
+
+// OpenFile returns a File reading the data of node. The context is used for
+// every fetch the File makes.
+func (f *Folder) OpenFile(ctx context.Context, node *ArqNode) (*File, error) {
+	if node.IsTree {
+		return nil, errors.New("can't open a tree node as a file")
+	}
+	return &File{
+		ctx:     ctx,
+		f:       f,
+		node:    node,
+		starts:  []int64{0},
+		fetches: make(map[int]*chunkFetch),
+	}, nil
+}
+
+// Size returns the length of the file's data.
+func (fl *File) Size() int64 {
+	return int64(fl.node.DataSize)
+}
+
+// ReadAt implements io.ReaderAt.
+func (fl *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("arq.File.ReadAt: negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off+int64(n) >= fl.Size() {
+			return n, io.EOF
+		}
+		i, start, err := fl.locate(off + int64(n))
+		if err != nil {
+			return n, err
+		}
+		data, err := fl.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		pos := off + int64(n) - start
+		if pos >= int64(len(data)) {
+			return n, fmt.Errorf("chunk %d has %d bytes, expected more than %d", i, len(data), pos)
+		}
+		n += copy(p[n:], data[pos:])
+	}
+	return n, nil
+}
+
+// Read implements io.Reader, reading from the current offset.
+func (fl *File) Read(p []byte) (int, error) {
+	fl.offsetMu.Lock()
+	defer fl.offsetMu.Unlock()
+	n, err := fl.ReadAt(p, fl.offset)
+	fl.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	fl.offsetMu.Lock()
+	defer fl.offsetMu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fl.offset
+	case io.SeekEnd:
+		offset += fl.Size()
+	default:
+		return 0, errors.New("arq.File.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("arq.File.Seek: negative position")
+	}
+	fl.offset = offset
+	return offset, nil
+}
+
+// locate returns the index and offset of the chunk containing off, which
+// must be within the file, learning the offsets of the chunks before it as
+// needed.
+func (fl *File) locate(off int64) (int, int64, error) {
+	n := len(fl.node.DataBlobKeys)
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	for {
+		if i := sort.Search(len(fl.starts), func(i int) bool { return fl.starts[i] > off }); i < len(fl.starts) {
+			return i - 1, fl.starts[i-1], nil
+		}
+		// The last chunk ends at the end of the file, so its size is never
+		// needed.
+		i := len(fl.starts) - 1
+		if i == n-1 {
+			return i, fl.starts[i], nil
+		}
+		fl.mu.Unlock()
+		size, err := fl.chunkLen(i)
+		fl.mu.Lock()
+		if err != nil {
+			return 0, 0, err
+		}
+		// Another call may have learnt the offset while the lock wasn't held.
+		if len(fl.starts) == i+1 {
+			fl.starts = append(fl.starts, fl.starts[i]+size)
+		}
+	}
+}
+
+// chunkLen returns the decompressed size of chunk i.
+func (fl *File) chunkLen(i int) (int64, error) {
+	fl.mu.Lock()
+	for _, c := range fl.cache {
+		if c.i == i {
+			fl.mu.Unlock()
+			return int64(len(c.data)), nil
+		}
+	}
+	fl.mu.Unlock()
+	if fl.node.DataCompressionType != Lz4Compression {
+		data, err := fl.chunk(i)
+		return int64(len(data)), err
+	}
+	h := fl.node.DataBlobKeys[i].Hash
+	rc, err := fl.f.computer.Objects().GetRaw(fl.ctx, h)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	var length int32
+	if err := binary.Read(rc, binary.BigEndian, &length); err != nil {
+		return 0, fmt.Errorf("object %s: %w", h, err)
+	}
+	if length < 0 {
+		return 0, fmt.Errorf("object %s: invalid LZ4 decompressed length %d", h, length)
+	}
+	return int64(length), nil
+}
+
+// chunk returns the decompressed contents of chunk i, from the cache if
+// possible, or by waiting for another call fetching it.
+func (fl *File) chunk(i int) ([]byte, error) {
+	fl.mu.Lock()
+	for j, c := range fl.cache {
+		if c.i == i {
+			copy(fl.cache[1:j+1], fl.cache[:j])
+			fl.cache[0] = c
+			fl.mu.Unlock()
+			return c.data, nil
+		}
+	}
+	if cf, ok := fl.fetches[i]; ok {
+		fl.mu.Unlock()
+		<-cf.done
+		return cf.data, cf.err
+	}
+	cf := &chunkFetch{done: make(chan struct{})}
+	fl.fetches[i] = cf
+	fl.mu.Unlock()
+
+	cf.data, cf.err = fl.f.ReadChunk(fl.ctx, fl.node, i)
+
+	fl.mu.Lock()
+	delete(fl.fetches, i)
+	if cf.err == nil {
+		if len(fl.cache) < fileChunkCacheSize {
+			fl.cache = append(fl.cache, cachedChunk{})
+		}
+		copy(fl.cache[1:], fl.cache)
+		fl.cache[0] = cachedChunk{i: i, data: cf.data}
+	}
+	fl.mu.Unlock()
+	close(cf.done)
+	return cf.data, cf.err
+}
@@ -0,0 +1,240 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	h, _ := arq.DecodeShaHashString("917ba67b0748ebbf02f12cdf2b49f536e5ddb20e")
+	commit, err := f.Commit(ctx, h)
+	if !assert.Nil(t, err) {
+		return
+	}
+	big, err := f.Lookup(ctx, commit, "2600-0.txt")
+	if !assert.Nil(t, err) {
+		return
+	}
+	small, err := f.Lookup(ctx, commit, "one.txt")
+	if !assert.Nil(t, err) {
+		return
+	}
+	bigData, err := ioutil.ReadFile("testdata/t1/src/2600-0.txt")
+	if !assert.Nil(t, err) {
+		return
+	}
+	smallData, err := ioutil.ReadFile("testdata/t1/src/one.txt")
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Equal(t, big.DataCompressionType, small.DataCompressionType) {
+		return
+	}
+
+	// Every file in the testdata is a single chunk, so make up a node with
+	// several.
+	node := &arq.ArqNode{
+		DataCompressionType: big.DataCompressionType,
+		DataBlobKeys: []arq.ArqBlobKey{
+			small.DataBlobKeys[0],
+			big.DataBlobKeys[0],
+			small.DataBlobKeys[0],
+			big.DataBlobKeys[0],
+		},
+	}
+	var expected []byte
+	for _, d := range [][]byte{smallData, bigData, smallData, bigData} {
+		expected = append(expected, d...)
+	}
+	node.DataSize = uint64(len(expected))
+
+	t.Run("ReadAt", func(t *testing.T) {
+		fl, err := f.OpenFile(ctx, node)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, int64(len(expected)), fl.Size())
+		for _, tc := range []struct {
+			off, len int
+		}{
+			// Within the last chunk, before any others have been read.
+			{len(expected) - 100, 50},
+			{0, 10},
+			// Spanning three chunks.
+			{20, len(bigData) + 10},
+			{len(smallData), 1},
+			{len(smallData) - 1, 2},
+		} {
+			buf := make([]byte, tc.len)
+			n, err := fl.ReadAt(buf, int64(tc.off))
+			assert.Nil(t, err)
+			assert.Equal(t, tc.len, n)
+			assert.Equal(t, expected[tc.off:tc.off+tc.len], buf[:n], "ReadAt(%d, %d)", tc.off, tc.len)
+		}
+
+		buf := make([]byte, 100)
+		n, err := fl.ReadAt(buf, int64(len(expected)-10))
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, expected[len(expected)-10:], buf[:n])
+		_, err = fl.ReadAt(buf, int64(len(expected)))
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("SeekAndRead", func(t *testing.T) {
+		fl, err := f.OpenFile(ctx, node)
+		if !assert.Nil(t, err) {
+			return
+		}
+		off, err := fl.Seek(-int64(len(bigData)+5), io.SeekEnd)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(expected)-len(bigData)-5), off)
+		actual, err := io.ReadAll(fl)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(expected[off:], actual))
+
+		_, err = fl.Seek(0, io.SeekStart)
+		assert.Nil(t, err)
+		actual, err = io.ReadAll(fl)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(expected, actual))
+
+		_, err = fl.Seek(10, io.SeekEnd)
+		assert.Nil(t, err)
+		n, err := fl.Read(make([]byte, 10))
+		assert.Equal(t, 0, n)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("ConcurrentRead", func(t *testing.T) {
+		fl, err := f.OpenFile(ctx, node)
+		if !assert.Nil(t, err) {
+			return
+		}
+		// Every Read moves the shared offset, so between them the goroutines
+		// read each byte exactly once.
+		var mu sync.Mutex
+		var total int
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := make([]byte, 64*1024)
+				for {
+					n, err := fl.Read(buf)
+					mu.Lock()
+					total += n
+					mu.Unlock()
+					if err != nil {
+						assert.Equal(t, io.EOF, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, len(expected), total)
+	})
+
+	t.Run("ConcurrentReadAt", func(t *testing.T) {
+		fl, err := f.OpenFile(ctx, node)
+		if !assert.Nil(t, err) {
+			return
+		}
+		// Each goroutine reads its own part of the file, from the end
+		// backwards, so they fetch the same chunks and learn their offsets
+		// at the same time.
+		const parts = 8
+		size := len(expected) / parts
+		var wg sync.WaitGroup
+		for i := 0; i < parts; i++ {
+			wg.Add(1)
+			go func(off int) {
+				defer wg.Done()
+				buf := make([]byte, size)
+				n, err := fl.ReadAt(buf, int64(off))
+				assert.Nil(t, err, off)
+				assert.Equal(t, size, n, off)
+				assert.True(t, bytes.Equal(expected[off:off+size], buf), off)
+			}((parts - 1 - i) * size)
+		}
+		wg.Wait()
+	})
+
+	t.Run("OpenNode", func(t *testing.T) {
+		for _, prefetch := range []int{-1, 0, 1, 10} {
+			rc, err := f.OpenNode(ctx, node, &arq.OpenNodeOptions{Prefetch: prefetch})
+			if !assert.Nil(t, err) {
+				return
+			}
+			actual, err := io.ReadAll(rc)
+			assert.Nil(t, err, prefetch)
+			assert.True(t, bytes.Equal(expected, actual), prefetch)
+			assert.Nil(t, rc.Close())
+		}
+	})
+
+	t.Run("OpenNodeClose", func(t *testing.T) {
+		rc, err := f.OpenNode(ctx, node, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		buf := make([]byte, len(smallData)+10)
+		_, err = io.ReadFull(rc, buf)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[:len(buf)], buf)
+		assert.Nil(t, rc.Close())
+		_, err = rc.Read(buf)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("OpenNodeWrongSize", func(t *testing.T) {
+		wrong := *node
+		wrong.DataSize++
+		rc, err := f.OpenNode(ctx, &wrong, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer rc.Close()
+		_, err = io.ReadAll(rc)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("OpenNodeMissingChunk", func(t *testing.T) {
+		missing := *node
+		missing.DataBlobKeys = append([]arq.ArqBlobKey{}, node.DataBlobKeys...)
+		missing.DataBlobKeys[2] = arq.ArqBlobKey{}
+		rc, err := f.OpenNode(ctx, &missing, &arq.OpenNodeOptions{Prefetch: 3})
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer rc.Close()
+		actual, err := io.ReadAll(rc)
+		assert.NotNil(t, err)
+		// Everything before the missing chunk is still read.
+		assert.Equal(t, len(smallData)+len(bigData), len(actual))
+	})
+
+	t.Run("Tree", func(t *testing.T) {
+		dir, err := f.Lookup(ctx, commit, "somedir")
+		if !assert.Nil(t, err) {
+			return
+		}
+		_, err = f.OpenFile(ctx, dir)
+		assert.NotNil(t, err)
+		_, err = f.OpenNode(ctx, dir, nil)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,193 @@
+package arq
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+)
+
+// NodeType restricts a FindQuery to one kind of node.
+type NodeType int
+
+const (
+	AnyNode NodeType = iota
+	FileNode
+	DirNode
+	SymlinkNode
+)
+
+// FindQuery is what Finder searches for. A node matches if its name matches
+// any of Globs or Regexps, or they are both empty, and it passes every other
+// filter.
+type FindQuery struct {
+	// Patterns matched against the name of each node, as path.Match does.
+	Globs []string
+	// Matched against the name of each node.
+	Regexps []*regexp.Regexp
+	Type    NodeType
+	// The range of sizes to match, inclusive. A MaxSize of 0 has no limit.
+	MinSize uint64
+	MaxSize uint64
+	// The range of modification times to match, from After, inclusive, to
+	// Before, exclusive. Either may be zero.
+	After  time.Time
+	Before time.Time
+}
+
+func (q *FindQuery) matches(name string, node *ArqNode) bool {
+	switch q.Type {
+	case FileNode:
+		if !node.IsRegular() {
+			return false
+		}
+	case DirNode:
+		if !node.IsTree {
+			return false
+		}
+	case SymlinkNode:
+		if !node.IsSymlink() {
+			return false
+		}
+	}
+	if node.DataSize < q.MinSize || (q.MaxSize != 0 && node.DataSize > q.MaxSize) {
+		return false
+	}
+	if (!q.After.IsZero() && node.Mtime.Before(q.After)) || (!q.Before.IsZero() && !node.Mtime.Before(q.Before)) {
+		return false
+	}
+	if len(q.Globs) == 0 && len(q.Regexps) == 0 {
+		return true
+	}
+	for _, g := range q.Globs {
+		// The pattern was checked by NewFinder.
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	for _, re := range q.Regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindFunc is called by Finder.Find for every matching node. p is the path of
+// the node relative to the root of the folder. The node is shared with other
+// commits containing it, and mustn't be modified.
+type FindFunc func(p string, node *ArqNode) error
+
+// Finder searches commits for nodes matching a FindQuery.
+//
+// Arq only stores a subtree again when something in it changes, so most of
+// the trees of a commit are shared with the commits before it. A Finder
+// remembers what it found in every subtree it has searched, by hash, so that
+// searching many commits of a folder only loads the trees which differ. For
+// each subtree it keeps its metadata, the nodes directly inside it which
+// match, and the subtrees inside it containing matches, for as long as it is
+// used.
+type Finder struct {
+	q     FindQuery
+	trees map[ShaHash]*searchedTree
+}
+
+// searchedTree is what a Finder remembers of a subtree.
+type searchedTree struct {
+	// The tree's metadata, without its nodes.
+	tree *ArqTree
+	// The nodes inside the tree which match or contain matches, in order.
+	entries []findEntry
+}
+
+type findEntry struct {
+	name string
+	// The node, if it matches.
+	match *ArqNode
+	// The searched subtree, if the node is a directory containing matches.
+	child *searchedTree
+}
+
+// NewFinder returns a Finder for q, or an error if one of its globs is
+// malformed.
+func NewFinder(q FindQuery) (*Finder, error) {
+	for _, g := range q.Globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("glob '%s': %w", g, err)
+		}
+	}
+	return &Finder{q: q, trees: make(map[ShaHash]*searchedTree)}, nil
+}
+
+// Find calls fn for every node in the tree of commit which matches the
+// query, in lexical order. Subtrees are only searched once per Finder, so
+// f must be a folder of the same computer in every call.
+func (fd *Finder) Find(ctx context.Context, f *Folder, commit *ArqCommit, fn FindFunc) error {
+	st, err := fd.search(ctx, f, commit.TreeBlobKey.Hash, commit.TreeCompressionType)
+	if err != nil {
+		return err
+	}
+	return st.emit("", fn)
+}
+
+// emit calls fn for every match inside st, joining their paths to dir.
+func (st *searchedTree) emit(dir string, fn FindFunc) error {
+	for _, e := range st.entries {
+		p := path.Join(dir, e.name)
+		if e.match != nil {
+			if err := fn(p, e.match); err != nil {
+				return err
+			}
+		}
+		if e.child != nil {
+			if err := e.child.emit(p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fd *Finder) search(ctx context.Context, f *Folder, h ShaHash, ct CompressionType) (*searchedTree, error) {
+	if st, ok := fd.trees[h]; ok {
+		return st, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t, err := f.Tree(ctx, h, ct)
+	if err != nil {
+		return nil, err
+	}
+	var entries []findEntry
+	for i := range t.Nodes {
+		e := findEntry{name: t.Nodes[i].FileName}
+		node := &t.Nodes[i].Node
+		if node.IsTree {
+			if len(node.DataBlobKeys) != 1 {
+				return nil, fmt.Errorf("'%s': tree node has %d blob keys, expected 1", e.name, len(node.DataBlobKeys))
+			}
+			child, err := fd.search(ctx, f, node.DataBlobKeys[0].Hash, node.DataCompressionType)
+			if err != nil {
+				return nil, fmt.Errorf("'%s': %w", e.name, err)
+			}
+			child.tree.copyMetadata(node)
+			if len(child.entries) > 0 {
+				e.child = child
+			}
+		}
+		if fd.q.matches(e.name, node) {
+			// A copy, so the rest of the tree's nodes can be freed.
+			match := *node
+			e.match = &match
+		}
+		if e.match != nil || e.child != nil {
+			entries = append(entries, e)
+		}
+	}
+	t.Nodes = nil
+	st := &searchedTree{tree: t, entries: entries}
+	fd.trees[h] = st
+	return st, nil
+}
@@ -0,0 +1,111 @@
+package arq_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	commits := loadT1Commits(t, f, t1Commit1, t1Commit2, t1Commit3)
+	if commits == nil {
+		return
+	}
+
+	find := func(t *testing.T, fd *arq.Finder, commit *arq.ArqCommit) []string {
+		var found []string
+		err := fd.Find(ctx, f, commit, func(p string, node *arq.ArqNode) error {
+			found = append(found, p)
+			return nil
+		})
+		assert.Nil(t, err)
+		return found
+	}
+
+	for _, tc := range []struct {
+		name  string
+		q     arq.FindQuery
+		found [3][]string
+	}{
+		{"All", arq.FindQuery{}, [3][]string{
+			{"one.txt"},
+			{"2600-0.txt", "one.txt"},
+			{"2600-0.txt", "one.txt", "somedir", "somedir/two.txt"},
+		}},
+		{"Glob", arq.FindQuery{Globs: []string{"t*.txt", "o*"}}, [3][]string{
+			{"one.txt"},
+			{"one.txt"},
+			{"one.txt", "somedir/two.txt"},
+		}},
+		{"Regexp", arq.FindQuery{Regexps: []*regexp.Regexp{regexp.MustCompile(`^\d+-`)}}, [3][]string{
+			nil,
+			{"2600-0.txt"},
+			{"2600-0.txt"},
+		}},
+		{"Dirs", arq.FindQuery{Type: arq.DirNode}, [3][]string{
+			nil,
+			nil,
+			{"somedir"},
+		}},
+		{"Files", arq.FindQuery{Type: arq.FileNode, Globs: []string{"some*", "two.txt"}}, [3][]string{
+			nil,
+			nil,
+			{"somedir/two.txt"},
+		}},
+		{"Size", arq.FindQuery{MinSize: 100, MaxSize: 1000}, [3][]string{
+			nil,
+			nil,
+			{"somedir", "somedir/two.txt"},
+		}},
+		{"Mtime", arq.FindQuery{
+			After:  time.Date(2021, 5, 7, 0, 0, 0, 0, time.UTC),
+			Before: time.Date(2021, 5, 29, 0, 0, 0, 0, time.UTC),
+		}, [3][]string{
+			{"one.txt"},
+			{"2600-0.txt", "one.txt"},
+			{"2600-0.txt", "one.txt"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fd, err := arq.NewFinder(tc.q)
+			if !assert.Nil(t, err) {
+				return
+			}
+			// Newest first, so the later searches use the remembered
+			// subtrees.
+			for i := len(commits) - 1; i >= 0; i-- {
+				assert.Equal(t, tc.found[i], find(t, fd, commits[i]), "commit %d", i)
+			}
+		})
+	}
+
+	t.Run("Stop", func(t *testing.T) {
+		fd, err := arq.NewFinder(arq.FindQuery{})
+		if !assert.Nil(t, err) {
+			return
+		}
+		stop := errors.New("stop")
+		n := 0
+		err = fd.Find(ctx, f, commits[2], func(p string, node *arq.ArqNode) error {
+			n++
+			return stop
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("BadGlob", func(t *testing.T) {
+		_, err := arq.NewFinder(arq.FindQuery{Globs: []string{"["}})
+		assert.NotNil(t, err)
+	})
+}
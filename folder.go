@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/rclone/rclone/fs"
 )
@@ -17,6 +18,16 @@ type Folder struct {
 	uuid     string
 	computer *Computer
 	fInfo    *FolderInfo
+
+	mu sync.Mutex
+	// The most recently loaded subtrees, most recent first, so that loading
+	// a directory's metadata and then its contents only fetches it once.
+	subtrees []cachedTree
+}
+
+type cachedTree struct {
+	h ShaHash
+	t *ArqTree
 }
 
 func (f *Folder) FindMaster(ctx context.Context) (ShaHash, error) {
@@ -83,10 +94,15 @@ func (f *Folder) ListRefs(ctx context.Context) ([]RefListEntry, error) {
 }
 
 type RefEntry struct {
+	// The previous head, empty for a folder's first commit.
+	OldHeadSha1       string `plist:"oldHeadSHA1"`
 	OldHeadStretchKey bool   `plist:"oldHeadStretchKey"`
 	NewHeadSha1       string `plist:"newHeadSHA1"`
 	NewHeadStretchKey bool   `plist:"newHeadStretchKey"`
-	PackSha1          string `plist:"packSHA1"`
+	// IsRewrite is set when Arq replaced the history rather than adding a
+	// commit to it, so the old head may no longer be reachable.
+	IsRewrite bool   `plist:"isRewrite"`
+	PackSha1  string `plist:"packSHA1"`
 }
 
 func (f *Folder) RefEntry(ctx context.Context, name int) (RefEntry, error) {
@@ -67,5 +67,7 @@ func TestFolder(t *testing.T) {
 		}
 		assert.Equal(t, "917ba67b0748ebbf02f12cdf2b49f536e5ddb20e", re.NewHeadSha1)
 		assert.Equal(t, "19cec4295c1d829dfb900007a0bebeb0b3727260", re.PackSha1)
+		assert.Equal(t, "e0534dd4c22365023f8a5e6312903ecbc1afba19", re.OldHeadSha1)
+		assert.False(t, re.IsRewrite)
 	})
 }
@@ -0,0 +1,53 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Format is the version of Arq a backup was made with.
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	// An Arq 5 Computer.
+	FormatArq5
+	// An Arq 6 or 7 BackupSet.
+	FormatArq7
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatArq5:
+		return "Arq 5"
+	case FormatArq7:
+		return "Arq 7"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectFormat returns the format of the backup in the directory dir, which
+// is named for the UUID of an Arq 5 computer or an Arq 6 or 7 backup set. It
+// returns FormatUnknown if dir is neither.
+func DetectFormat(ctx context.Context, f fs.Fs, dir string) (Format, error) {
+	for _, c := range []struct {
+		fname  string
+		format Format
+	}{
+		{"backupconfig.json", FormatArq7},
+		{"computerinfo", FormatArq5},
+	} {
+		_, err := f.NewObject(ctx, path.Join(dir, c.fname))
+		if err == nil {
+			return c.format, nil
+		}
+		if !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorDirNotFound) {
+			return FormatUnknown, err
+		}
+	}
+	return FormatUnknown, nil
+}
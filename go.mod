@@ -3,6 +3,8 @@ module github.com/sholiday/arq
 go 1.16
 
 require (
+	github.com/hanwen/go-fuse/v2 v2.1.0
+	github.com/pierrec/lz4/v4 v4.1.8
 	github.com/rclone/rclone v1.55.1
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/crypto v0.0.0-20210506145944-38f3c27a63bf
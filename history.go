@@ -0,0 +1,86 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Version is a distinct version of a file, which may be in several commits.
+type Version struct {
+	// The file in the most recent commit containing this version.
+	Node *ArqNode
+	// The commits containing this version, most recent first, and their
+	// creation dates.
+	Commits []Snapshot
+}
+
+// History returns every distinct version of the file at path p across the
+// snapshots of f, most recent first. Versions are told apart by the hashes of
+// their data, so a file whose metadata changed but whose contents didn't is a
+// single version. Snapshots which are missing, or don't contain p, are
+// skipped.
+func History(ctx context.Context, f *Folder, p string) ([]Version, error) {
+	snapshots, err := f.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	byData := make(map[string]int)
+	for _, s := range snapshots {
+		if s.Commit == nil {
+			continue
+		}
+		node, err := f.Lookup(ctx, s.Commit, p)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", s.Hash, err)
+		}
+		key := dataKey(node)
+		if i, ok := byData[key]; ok {
+			versions[i].Commits = append(versions[i].Commits, s)
+			continue
+		}
+		byData[key] = len(versions)
+		versions = append(versions, Version{Node: node, Commits: []Snapshot{s}})
+	}
+	return versions, nil
+}
+
+// dataKey identifies the contents of node by the hashes of its data.
+func dataKey(node *ArqNode) string {
+	var sb strings.Builder
+	if node.IsTree {
+		sb.WriteString("tree:")
+	}
+	for _, bk := range node.DataBlobKeys {
+		sb.WriteString(bk.Hash.String())
+	}
+	return sb.String()
+}
+
+// RestoreNode restores a single file or symlink, like a version returned by
+// History, to target.
+func RestoreNode(ctx context.Context, f *Folder, node *ArqNode, target string, opts RestoreOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	switch {
+	case node.IsSymlink():
+		if err := restoreSymlink(ctx, f, target, node, opts); err != nil {
+			return err
+		}
+	case node.IsRegular():
+		if err := restoreFile(ctx, f, target, node, opts); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("can't restore %s, only files and symlinks", node.FileMode().Type())
+	}
+	return restoreMetadata(ctx, f, target, node, opts)
+}
@@ -0,0 +1,141 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("T1", func(t *testing.T) {
+		f := openT1Folder(t)
+		if f == nil {
+			return
+		}
+		for _, tc := range []struct {
+			p       string
+			commits []string
+		}{
+			{"one.txt", []string{t1Commit3, t1Commit2, t1Commit1}},
+			{"2600-0.txt", []string{t1Commit3, t1Commit2}},
+			{"somedir/two.txt", []string{t1Commit3}},
+			{"missing.txt", nil},
+		} {
+			versions, err := arq.History(ctx, f, tc.p)
+			if !assert.Nil(t, err, tc.p) {
+				continue
+			}
+			if tc.commits == nil {
+				assert.Equal(t, 0, len(versions), tc.p)
+				continue
+			}
+			if !assert.Equal(t, 1, len(versions), tc.p) {
+				continue
+			}
+			var commits []string
+			for _, s := range versions[0].Commits {
+				commits = append(commits, s.Hash.String())
+			}
+			assert.Equal(t, tc.commits, commits, tc.p)
+		}
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		dir := copyT1(t)
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		// Make a new head which replaces the contents of 2600-0.txt with
+		// those of one.txt, leaving the third commit only in the reflog.
+		commits := loadT1Commits(t, f, t1Commit3)
+		if commits == nil {
+			return
+		}
+		tree, err := f.Tree(ctx, commits[0].TreeBlobKey.Hash, commits[0].TreeCompressionType)
+		if !assert.Nil(t, err) {
+			return
+		}
+		var one arq.ArqNode
+		for _, n := range tree.Nodes {
+			if n.FileName == "one.txt" {
+				one = n.Node
+			}
+		}
+		for i := range tree.Nodes {
+			if tree.Nodes[i].FileName == "2600-0.txt" {
+				tree.Nodes[i].Node.DataBlobKeys = one.DataBlobKeys
+				tree.Nodes[i].Node.DataSize = one.DataSize
+			}
+		}
+		var plain bytes.Buffer
+		if !assert.Nil(t, arq.EncodeArq(&plain, tree)) || !assert.Equal(t, arq.Lz4Compression, commits[0].TreeCompressionType) {
+			return
+		}
+		const (
+			treeHash   = "0011223344556677889900112233445566778899"
+			commitHash = "99887766554433221100998877665544332211aa"
+		)
+		writeT1Object(t, dir, treeHash, lz4Frame(t, plain.Bytes()))
+		h, _ := arq.DecodeShaHashString(t1Commit3)
+		rc, err := t1.OpenComputer(t, dir).Objects().GetRaw(ctx, h)
+		if !assert.Nil(t, err) {
+			return
+		}
+		commit, err := io.ReadAll(rc)
+		rc.Close()
+		if !assert.Nil(t, err) {
+			return
+		}
+		old := []byte(commits[0].TreeBlobKey.Hash.String())
+		if !assert.True(t, bytes.Contains(commit, old)) {
+			return
+		}
+		writeT1Object(t, dir, commitHash, bytes.Replace(commit, old, []byte(treeHash), 1))
+		writeFile(t, filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7", "bucketdata", "9084C9D4-B59E-4F94-A577-CF5FCFF23056", "refs", "heads", "master"), []byte(commitHash+"Y"))
+		writeRefEntry(t, dir, 644400000, t1Commit3, commitHash, true)
+
+		versions, err := arq.History(ctx, f, "2600-0.txt")
+		if !assert.Nil(t, err) || !assert.Equal(t, 2, len(versions)) {
+			return
+		}
+		assert.Equal(t, one.DataSize, versions[0].Node.DataSize)
+		if assert.Equal(t, 1, len(versions[0].Commits)) {
+			assert.Equal(t, commitHash, versions[0].Commits[0].Hash.String())
+			assert.Equal(t, arq.SnapshotCurrent, versions[0].Commits[0].Status)
+		}
+		if assert.Equal(t, 2, len(versions[1].Commits)) {
+			assert.Equal(t, t1Commit3, versions[1].Commits[0].Hash.String())
+			assert.Equal(t, arq.SnapshotRewritten, versions[1].Commits[0].Status)
+			assert.Equal(t, t1Commit2, versions[1].Commits[1].Hash.String())
+		}
+
+		// Restore each version.
+		for i, src := range []string{"one.txt", "2600-0.txt"} {
+			target := filepath.Join(t.TempDir(), "restored", "2600-0.txt")
+			if !assert.Nil(t, arq.RestoreNode(ctx, f, versions[i].Node, target, arq.RestoreOptions{})) {
+				continue
+			}
+			expected, err := ioutil.ReadFile(filepath.Join("testdata/t1/src", src))
+			assert.Nil(t, err)
+			actual, err := ioutil.ReadFile(target)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(expected, actual), src)
+			fi, err := os.Stat(target)
+			if assert.Nil(t, err) {
+				assert.True(t, versions[i].Node.Mtime.Equal(fi.ModTime()))
+			}
+			assert.NotNil(t, arq.RestoreNode(ctx, f, versions[i].Node, target, arq.RestoreOptions{}))
+		}
+	})
+}
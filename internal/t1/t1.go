@@ -0,0 +1,63 @@
+// Package t1 opens the t1 testdata, a small Arq 5 backup of one folder made
+// with the passphrase "hunter2", for the tests of arq and its subpackages.
+package t1
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/pack/indexcache"
+	"github.com/stretchr/testify/assert"
+)
+
+const Passphrase = "hunter2"
+
+// Root returns the directory holding the t1 testdata: the backup in `local`
+// and the files it was made from in `src`.
+func Root() string {
+	_, fname, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(fname), "..", "..", "testdata", "t1")
+}
+
+// Dir returns the directory holding the t1 backup.
+func Dir() string {
+	return filepath.Join(Root(), "local")
+}
+
+// NewComputer returns the opened computer in dir, the t1 backup or a copy of
+// it, without any pack indexes loaded.
+func NewComputer(t testing.TB, dir string) *arq.Computer {
+	ctx := context.Background()
+	localFs, err := local.NewFs(ctx, "localfs", dir, configmap.New())
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	computers, err := arq.ListComputers(ctx, localFs, "")
+	if !assert.Nil(t, err) || !assert.Equal(t, 1, len(computers)) {
+		return nil
+	}
+	c := &computers[0]
+	if !assert.Nil(t, c.Open(ctx, Passphrase)) {
+		return nil
+	}
+	return c
+}
+
+// OpenComputer is like NewComputer, but the computer's pack indexes are
+// loaded into memory, so that packed objects can be found.
+func OpenComputer(t testing.TB, dir string) *arq.Computer {
+	c := NewComputer(t, dir)
+	if c == nil {
+		return nil
+	}
+	_, err := c.IndexPacks(context.Background(), indexcache.NewMapBackedCache(), nil)
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,236 @@
+package arq
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// objectCipher decrypts the objects of a computer, and knows the hash each
+// is stored under. Which one a computer uses depends on the version of Arq
+// that created it.
+type objectCipher interface {
+	decryptingReader(r io.Reader) io.Reader
+	// objectHash returns the hash an object with the given decompressed
+	// contents is stored under.
+	objectHash(data []byte) ShaHash
+}
+
+var (
+	_ objectCipher = (*encryptionV3)(nil)
+	_ objectCipher = (*legacyCipher)(nil)
+	_ objectCipher = noCipher{}
+)
+
+// unlockComputer detects how the computer at base is encrypted, and unlocks
+// it with passphrase. From newest to oldest:
+//
+//	encryptionv3.dat   Arq 5, keys encrypted under the passphrase
+//	encryptionv2.dat   Arq 4, the same but without a key for object hashes
+//	salt               Arq 3 and earlier, an OpenSSL style salt
+//
+// A computer with none of them isn't encrypted, and reading an Arq 4 or 5
+// encrypted object from it fails with ErrNoKeyFile.
+func unlockComputer(ctx context.Context, f fs.Fs, base, passphrase string) (objectCipher, error) {
+	for _, fname := range []string{"encryptionv3.dat", "encryptionv2.dat", "salt"} {
+		obj, err := f.NewObject(ctx, path.Join(base, fname))
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rc, err := obj.Open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch fname {
+		case "encryptionv3.dat", "encryptionv2.dat":
+			e, err := Unlock(ctx, rc, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fname, err)
+			}
+			return e, nil
+		default:
+			by, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			c, err := newLegacyCipher(passphrase, by)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fname, err)
+			}
+			return c, nil
+		}
+	}
+	return noCipher{}, nil
+}
+
+// ErrNoKeyFile is returned when reading an encrypted object from a computer
+// without encryptionv3.dat or one of the older files holding its keys, which
+// usually means they weren't copied along with its objects.
+var ErrNoKeyFile = errors.New("object is encrypted, but the computer has no key file")
+
+// noCipher is used by computers which aren't encrypted.
+type noCipher struct{}
+
+// decryptingReader returns the object as it is, unless it has the layout of
+// an Arq 4 or 5 encrypted object.
+func (noCipher) decryptingReader(r io.Reader) io.Reader {
+	return &plainObjectReader{r: r}
+}
+
+func (noCipher) objectHash(data []byte) ShaHash {
+	return ShaHash{Contents: sha1.Sum(data)}
+}
+
+// The length of the header of an encrypted object: "ARQO", a 32 byte HMAC, a
+// 16 byte IV and the 64 byte encrypted data IV and session key. The encrypted
+// data following it is at least one block long.
+const eObjectHeaderLen = 4 + 32 + 16 + 64
+
+// plainObjectReader passes an object through, but fails with ErrNoKeyFile at
+// its end if it starts with "ARQO" and has the length of an encrypted object.
+// Without the keys its HMAC can't be checked, so a plain object which happens
+// to look the same is rejected too, but that is rare.
+type plainObjectReader struct {
+	r       io.Reader
+	checked bool
+	// Whether the object may be encrypted, and how much of it has been read.
+	maybeEObject bool
+	n            int64
+}
+
+func (pr *plainObjectReader) Read(p []byte) (int, error) {
+	if !pr.checked {
+		head := make([]byte, eObjectHeaderLen+aes.BlockSize)
+		n, err := io.ReadFull(pr.r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		pr.maybeEObject = n == len(head) && bytes.HasPrefix(head, []byte("ARQO"))
+		pr.r = io.MultiReader(bytes.NewReader(head[:n]), pr.r)
+		pr.checked = true
+	}
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	if err == io.EOF && pr.maybeEObject && (pr.n-eObjectHeaderLen)%aes.BlockSize == 0 {
+		return n, ErrNoKeyFile
+	}
+	return n, err
+}
+
+var openSSLSaltedMagic = []byte("Salted__")
+
+// legacyCipher decrypts objects written before Arq 4, which are encrypted
+// with AES-256-CBC using a key and IV derived from the passphrase and the
+// computer's salt as OpenSSL's EVP_BytesToKey does, with SHA1 and 1000
+// iterations. The parameters are those arq_restore, Arq's open source
+// restorer (https://github.com/sreitshamer/arq_restore), passes to
+// EVP_BytesToKey in its CryptoKey.m for legacy keys.
+type legacyCipher struct {
+	passphrase string
+	key        [32]byte
+	iv         [16]byte
+}
+
+// The number of times EVP_BytesToKey hashes each block of key material.
+const legacyKeyIterations = 1000
+
+// newLegacyCipher returns a legacyCipher for the 8 byte salt, which may be
+// preceded by OpenSSL's "Salted__" magic.
+func newLegacyCipher(passphrase string, salt []byte) (*legacyCipher, error) {
+	salt = bytes.TrimPrefix(salt, openSSLSaltedMagic)
+	if len(salt) != 8 {
+		return nil, fmt.Errorf("invalid salt length %d, expected 8", len(salt))
+	}
+	c := &legacyCipher{passphrase: passphrase}
+	c.key, c.iv = evpBytesToKey(passphrase, salt)
+	return c, nil
+}
+
+// evpBytesToKey derives an AES-256 key and IV like OpenSSL's EVP_BytesToKey.
+func evpBytesToKey(passphrase string, salt []byte) ([32]byte, [16]byte) {
+	var material, prev []byte
+	for len(material) < 32+16 {
+		h := sha1.New()
+		h.Write(prev)
+		h.Write([]byte(passphrase))
+		h.Write(salt)
+		prev = h.Sum(nil)
+		for i := 1; i < legacyKeyIterations; i++ {
+			s := sha1.Sum(prev)
+			prev = s[:]
+		}
+		material = append(material, prev...)
+	}
+	var key [32]byte
+	var iv [16]byte
+	copy(key[:], material[:32])
+	copy(iv[:], material[32:48])
+	return key, iv
+}
+
+// decryptingReader decrypts an object, which may be prefixed with
+// "encrypted". Objects that carry their own OpenSSL "Salted__" header are
+// decrypted using that salt instead of the computer's.
+func (c *legacyCipher) decryptingReader(r io.Reader) io.Reader {
+	return &legacyObjectReader{r: r, c: c}
+}
+
+func (c *legacyCipher) objectHash(data []byte) ShaHash {
+	return ShaHash{Contents: sha1.Sum(data)}
+}
+
+type legacyObjectReader struct {
+	r     io.Reader
+	c     *legacyCipher
+	plain io.Reader
+}
+
+func (lr *legacyObjectReader) Read(p []byte) (int, error) {
+	if lr.plain == nil {
+		plain, err := lr.c.decrypt(lr.r)
+		if err != nil {
+			return 0, err
+		}
+		lr.plain = bytes.NewReader(plain)
+	}
+	return lr.plain.Read(p)
+}
+
+func (c *legacyCipher) decrypt(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("encrypted"))
+	key, iv := c.key, c.iv
+	if bytes.HasPrefix(data, openSSLSaltedMagic) && len(data) >= 16 {
+		key, iv = evpBytesToKey(c.passphrase, data[8:16])
+		data = data[16:]
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted object length %d", len(data))
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv[:]).CryptBlocks(plain, data)
+	plain, err = pkcs7Unpad(plain, aes.BlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting object, is the passphrase correct? %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,241 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+	"howett.net/plist"
+)
+
+const (
+	legacyComputerUuid = "5E1F3C2A-7B4D-4E6F-9A8B-1C2D3E4F5A6B"
+	legacyBucketUuid   = "6F2A4D3B-8C5E-4F7A-0B9C-2D3E4F5A6B7C"
+)
+
+// writeLegacyComputer writes a computer with one folder, and one loose
+// object containing "hello, world", using encrypt on both. It returns the
+// object's hash.
+func writeLegacyComputer(t *testing.T, dir string, encrypt func([]byte) []byte) arq.ShaHash {
+	base := filepath.Join(dir, legacyComputerUuid)
+	info, err := plist.Marshal(map[string]string{"BucketUUID": legacyBucketUuid, "BucketName": "src"}, plist.XMLFormat)
+	assert.Nil(t, err)
+	writeFile(t, filepath.Join(base, "buckets", legacyBucketUuid), encrypt(info))
+
+	data := []byte("hello, world")
+	h := arq.ShaHash{Contents: sha1.Sum(data)}
+	hStr := h.String()
+	writeFile(t, filepath.Join(base, "objects", hStr[:2], hStr[2:]), encrypt(data))
+	return h
+}
+
+func checkLegacyComputer(t *testing.T, dir, passphrase string, h arq.ShaHash) {
+	ctx := context.Background()
+	f, err := local.NewFs(ctx, "localfs", dir, configmap.New())
+	if !assert.Nil(t, err) {
+		return
+	}
+	c := arq.NewComputer(f, legacyComputerUuid)
+	if !assert.Nil(t, c.Open(ctx, passphrase)) {
+		return
+	}
+	folders, err := c.ListFolders(ctx)
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(folders)) {
+		assert.Equal(t, "src", folders[0].BucketName)
+	}
+	rc, err := c.Objects().GetRaw(ctx, h)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer rc.Close()
+	by, err := io.ReadAll(rc)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello, world", string(by))
+}
+
+// evpEncrypt encrypts data as OpenSSL's enc does, with the key and IV
+// derived using EVP_BytesToKey with SHA1 and 1000 iterations.
+func evpEncrypt(t *testing.T, passphrase string, salt, data []byte) []byte {
+	var material, prev []byte
+	for len(material) < 48 {
+		prev = append(append(append([]byte{}, prev...), passphrase...), salt...)
+		for i := 0; i < 1000; i++ {
+			s := sha1.Sum(prev)
+			prev = s[:]
+		}
+		material = append(material, prev...)
+	}
+	block, err := aes.NewCipher(material[:32])
+	assert.Nil(t, err)
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, material[32:48]).CryptBlocks(out, plain)
+	return out
+}
+
+func TestLegacyEncryption(t *testing.T) {
+	t.Run("Unencrypted", func(t *testing.T) {
+		dir := t.TempDir()
+		h := writeLegacyComputer(t, dir, func(data []byte) []byte { return data })
+		checkLegacyComputer(t, dir, "", h)
+	})
+
+	t.Run("Salt", func(t *testing.T) {
+		dir := t.TempDir()
+		salt, _ := hex.DecodeString("0102030405060708")
+		writeFile(t, filepath.Join(dir, legacyComputerUuid, "salt"), salt)
+		h := writeLegacyComputer(t, dir, func(data []byte) []byte {
+			return append([]byte("encrypted"), evpEncrypt(t, "hunter2", salt, data)...)
+		})
+		checkLegacyComputer(t, dir, "hunter2", h)
+	})
+
+	t.Run("ObjectSalt", func(t *testing.T) {
+		dir := t.TempDir()
+		salt, _ := hex.DecodeString("a1a2a3a4a5a6a7a8")
+		writeFile(t, filepath.Join(dir, legacyComputerUuid, "salt"), salt)
+		objectSalt, _ := hex.DecodeString("b1b2b3b4b5b6b7b8")
+		h := writeLegacyComputer(t, dir, func(data []byte) []byte {
+			// Each object may carry its own salt.
+			return append(append([]byte("Salted__"), objectSalt...), evpEncrypt(t, "hunter2", objectSalt, data)...)
+		})
+		checkLegacyComputer(t, dir, "hunter2", h)
+	})
+
+	t.Run("EncryptionV2", func(t *testing.T) {
+		dir := t.TempDir()
+		// Arq 4 only has two keys.
+		keys := make([]byte, 64)
+		rand.Read(keys)
+		keys = append(keys, bytes.Repeat([]byte{16}, 16)...)
+		salt, iv := make([]byte, 8), make([]byte, 16)
+		rand.Read(salt)
+		rand.Read(iv)
+		derived := pbkdf2.Key([]byte("hunter2"), salt, 200000, 64, sha1.New)
+		block, err := aes.NewCipher(derived[:32])
+		if !assert.Nil(t, err) {
+			return
+		}
+		encKeys := make([]byte, len(keys))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encKeys, keys)
+		mac := hmac.New(sha256.New, derived[32:])
+		mac.Write(iv)
+		mac.Write(encKeys)
+		dat := []byte("ENCRYPTIONV2")
+		for _, b := range [][]byte{salt, mac.Sum(nil), iv, encKeys} {
+			dat = append(dat, b...)
+		}
+		writeFile(t, filepath.Join(dir, legacyComputerUuid, "encryptionv2.dat"), dat)
+
+		enc, err := arq.Unlock(context.Background(), io.NopCloser(bytes.NewReader(dat)), "hunter2")
+		if !assert.Nil(t, err) {
+			return
+		}
+		h := writeLegacyComputer(t, dir, func(data []byte) []byte {
+			buf := new(bytes.Buffer)
+			w, err := arq.NewEObjectWriter(buf, enc)
+			assert.Nil(t, err)
+			w.Write(data)
+			assert.Nil(t, w.Close())
+			return buf.Bytes()
+		})
+		checkLegacyComputer(t, dir, "hunter2", h)
+	})
+
+	t.Run("PlainARQO", func(t *testing.T) {
+		dir := t.TempDir()
+		writeLegacyComputer(t, dir, func(data []byte) []byte { return data })
+		f, err := local.NewFs(context.Background(), "localfs", dir, configmap.New())
+		if !assert.Nil(t, err) {
+			return
+		}
+		c := arq.NewComputer(f, legacyComputerUuid)
+		if !assert.Nil(t, c.Open(context.Background(), "")) {
+			return
+		}
+		// Unencrypted objects which start like an encrypted one, but are
+		// too short, or not a whole number of blocks after its header.
+		for _, data := range [][]byte{
+			[]byte("ARQO, but not encrypted"),
+			append([]byte("ARQO"), bytes.Repeat([]byte{'x'}, 200)...),
+		} {
+			h := arq.ShaHash{Contents: sha1.Sum(data)}
+			hStr := h.String()
+			writeFile(t, filepath.Join(dir, legacyComputerUuid, "objects", hStr[:2], hStr[2:]), data)
+			rc, err := c.Objects().GetRaw(context.Background(), h)
+			if !assert.Nil(t, err) {
+				continue
+			}
+			by, err := io.ReadAll(rc)
+			rc.Close()
+			assert.Nil(t, err)
+			assert.Equal(t, data, by)
+		}
+	})
+
+	t.Run("MissingKeyFile", func(t *testing.T) {
+		dir := t.TempDir()
+		rc, err := os.Open(filepath.Join(t1.Dir(), "8C10C697-7DCA-4747-B92B-6900CC64CCE7", "encryptionv3.dat"))
+		if !assert.Nil(t, err) {
+			return
+		}
+		enc, err := arq.Unlock(context.Background(), rc, t1.Passphrase)
+		if !assert.Nil(t, err) {
+			return
+		}
+		writeLegacyComputer(t, dir, func(data []byte) []byte {
+			buf := new(bytes.Buffer)
+			w, err := arq.NewEObjectWriter(buf, enc)
+			assert.Nil(t, err)
+			w.Write(data)
+			assert.Nil(t, w.Close())
+			return buf.Bytes()
+		})
+		f, err := local.NewFs(context.Background(), "localfs", dir, configmap.New())
+		if !assert.Nil(t, err) {
+			return
+		}
+		c := arq.NewComputer(f, legacyComputerUuid)
+		if !assert.Nil(t, c.Open(context.Background(), "hunter2")) {
+			return
+		}
+		_, err = c.ListFolders(context.Background())
+		assert.ErrorIs(t, err, arq.ErrNoKeyFile)
+	})
+
+	t.Run("WrongPassphrase", func(t *testing.T) {
+		dir := t.TempDir()
+		salt, _ := hex.DecodeString("0102030405060708")
+		writeFile(t, filepath.Join(dir, legacyComputerUuid, "salt"), salt)
+		writeLegacyComputer(t, dir, func(data []byte) []byte {
+			return evpEncrypt(t, "hunter2", salt, data)
+		})
+		f, err := local.NewFs(context.Background(), "localfs", dir, configmap.New())
+		if !assert.Nil(t, err) {
+			return
+		}
+		c := arq.NewComputer(f, legacyComputerUuid)
+		if !assert.Nil(t, c.Open(context.Background(), "hunter3")) {
+			return
+		}
+		_, err = c.ListFolders(context.Background())
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,254 @@
+// Package mount exposes the history of Arq backups as a read-only FUSE
+// filesystem, laid out as `/<computer>/<folder>/<commit date>/...`.
+//
+// Everything is loaded lazily: folders, commits and trees are only read when a
+// directory is first listed or looked up, and file data is fetched one chunk
+// at a time as it is read.
+package mount
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/sholiday/arq"
+)
+
+// Format of the directory names for each commit.
+const commitTimeFormat = "2006-01-02T15:04:05"
+
+// How long the kernel may cache names and attributes. Trees never change, but
+// new folders and commits can appear.
+const cacheTimeout = time.Second
+
+type Options struct {
+	// Debug logs every FUSE request.
+	Debug bool
+	// AllowOther lets users other than the one mounting access the files.
+	AllowOther bool
+}
+
+// Mount mounts the history of every computer at mountpoint. Each computer
+// must already be opened, with a PackSearcher set.
+func Mount(mountpoint string, computers []*arq.Computer, opts Options) (*fuse.Server, error) {
+	timeout := cacheTimeout
+	return fs.Mount(mountpoint, NewRoot(computers), &fs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: fuse.MountOptions{
+			FsName:      "arq",
+			Name:        "arq",
+			Debug:       opts.Debug,
+			AllowOther:  opts.AllowOther,
+			DirectMount: true,
+		},
+	})
+}
+
+// NewRoot returns the root directory of the filesystem, containing a
+// directory for each computer.
+func NewRoot(computers []*arq.Computer) fs.InodeEmbedder {
+	return &dir{
+		mtime: time.Now(),
+		load: func(ctx context.Context) ([]dirEntry, error) {
+			names := newNamer()
+			entries := make([]dirEntry, 0, len(computers))
+			for _, c := range computers {
+				c := c
+				entries = append(entries, dirEntry{
+					name: names.name(c.Info.ComputerName, c.Uuid),
+					mode: fuse.S_IFDIR,
+					new:  func() fs.InodeEmbedder { return newComputerDir(c) },
+				})
+			}
+			return entries, nil
+		},
+	}
+}
+
+func newComputerDir(c *arq.Computer) *dir {
+	return &dir{
+		mtime: time.Now(),
+		load: func(ctx context.Context) ([]dirEntry, error) {
+			folders, err := c.ListFolders(ctx)
+			if err != nil {
+				return nil, err
+			}
+			names := newNamer()
+			entries := make([]dirEntry, 0, len(folders))
+			for i := range folders {
+				f := folders[i].Folder()
+				entries = append(entries, dirEntry{
+					name: names.name(folders[i].BucketName, folders[i].BucketUuid),
+					mode: fuse.S_IFDIR,
+					new:  func() fs.InodeEmbedder { return newFolderDir(f) },
+				})
+			}
+			return entries, nil
+		},
+	}
+}
+
+// newFolderDir creates the directory for a folder, with an entry for each
+// commit in its reflog. Entries are named for when their reflog entry was
+// written, so listing the directory doesn't load any commits.
+func newFolderDir(f *arq.Folder) *dir {
+	return &dir{
+		mtime: time.Now(),
+		load: func(ctx context.Context) ([]dirEntry, error) {
+			refs, err := f.ListRefs(ctx)
+			if err != nil {
+				return nil, err
+			}
+			names := newNamer()
+			entries := make([]dirEntry, 0, len(refs))
+			for _, ref := range refs {
+				ref := ref
+				date := ref.Time().Local().Format(commitTimeFormat)
+				entries = append(entries, dirEntry{
+					name: names.name(date, fmt.Sprintf("%s-%d", date, ref.Name)),
+					mode: fuse.S_IFDIR,
+					new:  func() fs.InodeEmbedder { return newCommitDir(f, ref) },
+				})
+			}
+			return entries, nil
+		},
+	}
+}
+
+// namer picks unique names for directory entries, using the fallback if the
+// preferred name is empty or taken.
+type namer map[string]bool
+
+func newNamer() namer {
+	return make(namer)
+}
+
+func (n namer) name(preferred, fallback string) string {
+	name := preferred
+	if name == "" || n[name] {
+		name = fallback
+	}
+	for i := 2; n[name]; i++ {
+		name = fmt.Sprintf("%s (%d)", fallback, i)
+	}
+	n[name] = true
+	return name
+}
+
+type dirEntry struct {
+	name string
+	mode uint32
+	// new creates the node for the entry. A fresh node is needed each time the
+	// kernel forgets the entry and looks it up again.
+	new func() fs.InodeEmbedder
+}
+
+// dir is a read-only directory whose entries are loaded the first time they
+// are needed.
+type dir struct {
+	fs.Inode
+
+	load func(ctx context.Context) ([]dirEntry, error)
+
+	mu sync.Mutex
+	// load may update mtime, once it knows better.
+	mtime   time.Time
+	loaded  bool
+	names   []string
+	entries map[string]dirEntry
+}
+
+var (
+	_ fs.NodeGetattrer = &dir{}
+	_ fs.NodeLookuper  = &dir{}
+	_ fs.NodeReaddirer = &dir{}
+)
+
+func (d *dir) children(ctx context.Context) ([]string, map[string]dirEntry, syscall.Errno) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loaded {
+		return d.names, d.entries, 0
+	}
+	entries, err := d.load(ctx)
+	if err != nil {
+		log.Printf("listing '%s': %v", d.Path(nil), err)
+		return nil, nil, syscall.EIO
+	}
+	d.names = make([]string, 0, len(entries))
+	d.entries = make(map[string]dirEntry, len(entries))
+	for _, e := range entries {
+		d.names = append(d.names, e.name)
+		d.entries[e.name] = e
+	}
+	sort.Strings(d.names)
+	d.loaded = true
+	return d.names, d.entries, 0
+}
+
+func (d *dir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	d.mu.Lock()
+	mtime := d.mtime
+	d.mu.Unlock()
+	out.Mode = fuse.S_IFDIR | 0555
+	setTimes(&out.Attr, mtime, mtime)
+	setOwner(&out.Attr)
+	return 0
+}
+
+func (d *dir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	_, entries, errno := d.children(ctx)
+	if errno != 0 {
+		return nil, errno
+	}
+	e, ok := entries[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	child := d.GetChild(name)
+	if child == nil {
+		child = d.NewInode(ctx, e.new(), fs.StableAttr{Mode: e.mode})
+	}
+	if ga, ok := child.Operations().(fs.NodeGetattrer); ok {
+		var attr fuse.AttrOut
+		if errno := ga.Getattr(ctx, nil, &attr); errno != 0 {
+			return nil, errno
+		}
+		out.Attr = attr.Attr
+	}
+	return child, 0
+}
+
+func (d *dir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	names, entries, errno := d.children(ctx)
+	if errno != 0 {
+		return nil, errno
+	}
+	list := make([]fuse.DirEntry, 0, len(names))
+	for _, name := range names {
+		list = append(list, fuse.DirEntry{
+			Name: name,
+			Mode: entries[name].mode,
+		})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func setTimes(attr *fuse.Attr, mtime, ctime time.Time) {
+	attr.SetTimes(&mtime, &mtime, &ctime)
+}
+
+// Files are presented as owned by whoever mounted the filesystem, the users
+// and groups on the backed up computer are unlikely to exist here.
+func setOwner(attr *fuse.Attr) {
+	attr.Uid = uint32(os.Getuid())
+	attr.Gid = uint32(os.Getgid())
+}
@@ -0,0 +1,63 @@
+package mount_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
+	"github.com/sholiday/arq/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMount(t *testing.T) {
+	c := t1.OpenComputer(t, t1.Dir())
+	if c == nil {
+		return
+	}
+	mnt, err := ioutil.TempDir("", "arqmount")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(mnt)
+
+	server, err := mount.Mount(mnt, []*arq.Computer{c}, mount.Options{})
+	if err != nil {
+		t.Skipf("unable to mount with FUSE: %v", err)
+	}
+	defer server.Unmount()
+
+	folder := filepath.Join(mnt, "narrator", "src")
+	commits, err := ioutil.ReadDir(folder)
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Equal(t, 3, len(commits)) {
+		return
+	}
+	latest := filepath.Join(folder, commits[len(commits)-1].Name())
+
+	for _, p := range []string{"2600-0.txt", "one.txt", "somedir/two.txt"} {
+		expected, err := ioutil.ReadFile(filepath.Join(t1.Root(), "src", p))
+		if !assert.Nil(t, err) {
+			continue
+		}
+		actual, err := ioutil.ReadFile(filepath.Join(latest, p))
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Equal(t, expected, actual, p)
+	}
+
+	// The first commit only contained one.txt.
+	entries, err := ioutil.ReadDir(filepath.Join(folder, commits[0].Name()))
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(entries)) {
+		assert.Equal(t, "one.txt", entries[0].Name())
+		assert.Equal(t, int64(26), entries[0].Size())
+	}
+
+	_, err = os.OpenFile(filepath.Join(latest, "one.txt"), os.O_WRONLY, 0)
+	assert.NotNil(t, err)
+}
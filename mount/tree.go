@@ -0,0 +1,163 @@
+package mount
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/sholiday/arq"
+)
+
+// newCommitDir creates the directory for the commit a reflog entry made the
+// head. The commit is only loaded when the directory is first listed or looked
+// up in, and if Arq has since removed it, doing so fails.
+func newCommitDir(f *arq.Folder, ref arq.RefListEntry) *dir {
+	d := &dir{mtime: ref.Time()}
+	d.load = func(ctx context.Context) ([]dirEntry, error) {
+		re, err := f.RefEntry(ctx, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		h, err := arq.DecodeShaHashString(re.NewHeadSha1)
+		if err != nil {
+			return nil, fmt.Errorf("reflog entry %d: %w", ref.Name, err)
+		}
+		commit, err := f.Commit(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", h, err)
+		}
+		t, err := f.Tree(ctx, commit.TreeBlobKey.Hash, commit.TreeCompressionType)
+		if err != nil {
+			return nil, err
+		}
+		d.mtime = t.Mtime
+		return treeEntries(f, t, commit.CreationDate), nil
+	}
+	return d
+}
+
+// newTreeDir creates the directory for a tree node. Until the tree is loaded
+// its mtime isn't known, so the commit's creation date is used instead.
+func newTreeDir(f *arq.Folder, node *arq.ArqNode, created time.Time) *dir {
+	d := &dir{mtime: created}
+	d.load = func(ctx context.Context) ([]dirEntry, error) {
+		if len(node.DataBlobKeys) != 1 {
+			return nil, fmt.Errorf("tree node has %d blob keys, expected 1", len(node.DataBlobKeys))
+		}
+		t, err := f.Tree(ctx, node.DataBlobKeys[0].Hash, node.DataCompressionType)
+		if err != nil {
+			return nil, err
+		}
+		d.mtime = t.Mtime
+		return treeEntries(f, t, created), nil
+	}
+	return d
+}
+
+func treeEntries(f *arq.Folder, t *arq.ArqTree, created time.Time) []dirEntry {
+	entries := make([]dirEntry, 0, len(t.Nodes))
+	for i := range t.Nodes {
+		node := &t.Nodes[i].Node
+		e := dirEntry{name: t.Nodes[i].FileName}
+		switch {
+		case node.IsTree:
+			e.mode = fuse.S_IFDIR
+			e.new = func() fs.InodeEmbedder { return newTreeDir(f, node, created) }
+		case node.IsSymlink():
+			e.mode = fuse.S_IFLNK
+			e.new = func() fs.InodeEmbedder { return &symlink{f: f, node: node} }
+		case node.IsRegular():
+			e.mode = fuse.S_IFREG
+			e.new = func() fs.InodeEmbedder { return &file{f: f, node: node} }
+		default:
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+type symlink struct {
+	fs.Inode
+	f    *arq.Folder
+	node *arq.ArqNode
+}
+
+var (
+	_ fs.NodeGetattrer  = &symlink{}
+	_ fs.NodeReadlinker = &symlink{}
+)
+
+func (s *symlink) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFLNK | 0777
+	out.Size = s.node.DataSize
+	setTimes(&out.Attr, s.node.Mtime, s.node.Ctime)
+	setOwner(&out.Attr)
+	return 0
+}
+
+func (s *symlink) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	var buf bytes.Buffer
+	if _, err := s.f.CopyNodeData(ctx, &buf, s.node); err != nil {
+		log.Printf("reading link '%s': %v", s.Path(nil), err)
+		return nil, syscall.EIO
+	}
+	return buf.Bytes(), 0
+}
+
+type file struct {
+	fs.Inode
+	f    *arq.Folder
+	node *arq.ArqNode
+}
+
+var (
+	_ fs.NodeGetattrer = &file{}
+	_ fs.NodeOpener    = &file{}
+)
+
+func (fl *file) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | uint32(fl.node.Mode)&0555
+	out.Size = fl.node.DataSize
+	out.Blocks = (fl.node.DataSize + 511) / 512
+	out.Nlink = 1
+	setTimes(&out.Attr, fl.node.Mtime, fl.node.Ctime)
+	setOwner(&out.Attr)
+	return 0
+}
+
+func (fl *file) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	// The handle outlives the request which opened it.
+	af, err := fl.f.OpenFile(context.Background(), fl.node)
+	if err != nil {
+		log.Printf("opening '%s': %v", fl.Path(nil), err)
+		return nil, 0, syscall.EIO
+	}
+	// Nothing in a backup ever changes, so the kernel can keep what it has
+	// read.
+	return &fileHandle{af}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+type fileHandle struct {
+	*arq.File
+}
+
+var _ fs.FileReader = &fileHandle{}
+
+func (h *fileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		log.Printf("reading: %v", err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
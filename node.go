@@ -0,0 +1,20 @@
+package arq
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// ReadChunk returns the decompressed contents of the i'th data chunk of node.
+func (f *Folder) ReadChunk(ctx context.Context, node *ArqNode, i int) ([]byte, error) {
+	if i < 0 || i >= len(node.DataBlobKeys) {
+		return nil, fmt.Errorf("chunk %d out of range, node has %d", i, len(node.DataBlobKeys))
+	}
+	rc, err := f.computer.Objects().Get(ctx, node.DataBlobKeys[i].Hash, node.DataCompressionType)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
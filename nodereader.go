@@ -0,0 +1,141 @@
+package arq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The number of chunks after the current one OpenNode fetches by default.
+const defaultNodePrefetch = 4
+
+type OpenNodeOptions struct {
+	// How many chunks after the one being read to fetch in the background,
+	// defaultNodePrefetch if 0. If negative, each chunk is only fetched once
+	// it is reached, and streamed rather than held in memory.
+	Prefetch int
+}
+
+// chunkResult is the outcome of fetching one chunk in the background.
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// nodeReader reads the data chunks of a node in order, fetching those ahead
+// of it in parallel.
+type nodeReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	f        *Folder
+	node     *ArqNode
+	prefetch int
+
+	// fetches[i] delivers chunk i, for each chunk fetched so far.
+	fetches []chan chunkResult
+	// The index of the next chunk to read.
+	next int
+	cur  io.ReadCloser
+	read uint64
+	err  error
+}
+
+// OpenNode returns a reader of the data of node, the contents of each of its
+// chunks decrypted, decompressed and concatenated in order. Reading fails if
+// the total length isn't the node's DataSize. A nil opts uses the defaults.
+//
+// Unlike OpenFile, the reader only reads forwards, but it fetches chunks ahead
+// of the one being read in parallel, which suits copying a whole file.
+func (f *Folder) OpenNode(ctx context.Context, node *ArqNode, opts *OpenNodeOptions) (io.ReadCloser, error) {
+	if node.IsTree {
+		return nil, errors.New("can't open a tree node as a file")
+	}
+	if opts == nil {
+		opts = &OpenNodeOptions{}
+	}
+	prefetch := opts.Prefetch
+	if prefetch == 0 {
+		prefetch = defaultNodePrefetch
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &nodeReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		f:        f,
+		node:     node,
+		prefetch: prefetch,
+	}, nil
+}
+
+func (r *nodeReader) Read(p []byte) (int, error) {
+	for r.err == nil {
+		if r.cur != nil {
+			n, err := r.cur.Read(p)
+			r.read += uint64(n)
+			if err == io.EOF {
+				r.cur.Close()
+				r.cur = nil
+				err = nil
+			}
+			if err != nil {
+				r.err = err
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		if r.next == len(r.node.DataBlobKeys) {
+			r.err = io.EOF
+			if r.read != r.node.DataSize {
+				r.err = fmt.Errorf("read %d bytes, expected %d", r.read, r.node.DataSize)
+			}
+			break
+		}
+		r.cur, r.err = r.open(r.next)
+		r.next++
+	}
+	return 0, r.err
+}
+
+// open returns a reader of chunk i, first starting the fetches of those after
+// it.
+func (r *nodeReader) open(i int) (io.ReadCloser, error) {
+	if r.prefetch < 0 {
+		return r.f.computer.Objects().Get(r.ctx, r.node.DataBlobKeys[i].Hash, r.node.DataCompressionType)
+	}
+	for j := len(r.fetches); j <= i+r.prefetch && j < len(r.node.DataBlobKeys); j++ {
+		// Buffered, so the fetch finishes even if the reader is closed.
+		ch := make(chan chunkResult, 1)
+		r.fetches = append(r.fetches, ch)
+		go func(j int) {
+			data, err := r.f.ReadChunk(r.ctx, r.node, j)
+			ch <- chunkResult{data, err}
+		}(j)
+	}
+	select {
+	case res := <-r.fetches[i]:
+		// Let the chunk be collected once it has been read.
+		r.fetches[i] = nil
+		if res.err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, res.err)
+		}
+		return io.NopCloser(bytes.NewReader(res.data)), nil
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+}
+
+// Close stops any fetches in progress.
+func (r *nodeReader) Close() error {
+	r.cancel()
+	if r.cur != nil {
+		r.cur.Close()
+		r.cur = nil
+	}
+	r.err = os.ErrClosed
+	return nil
+}
@@ -0,0 +1,189 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+var (
+	ErrNotFound        = errors.New("ErrNotFound")
+	ErrComputerNotOpen = errors.New("computer has not been opened")
+)
+
+// PackLocation is where an object is stored within a pack.
+type PackLocation struct {
+	// Offset of the object's entry in the pack, not of its data.
+	Offset   uint64
+	Length   uint64
+	PackHash ShaHash
+}
+
+// PackSearcher finds the pack an object is stored in. It is satisfied by any
+// indexcache.Searcher.
+type PackSearcher interface {
+	Find(ctx context.Context, h ShaHash) (PackLocation, error)
+}
+
+// The largest possible mimetype and name strings preceding an object's data
+// in a pack, plus the data length itself.
+const maxPackObjectHeaderLen = 2*(1+8+4096) + 8
+
+// ObjectStore fetches and decrypts objects stored by a Computer, whether they
+// are loose in `objects/` or stored in a pack under `packsets/`.
+type ObjectStore struct {
+	c        *Computer
+	searcher PackSearcher
+
+	mu sync.Mutex
+	// Packs found under `packsets/`, keyed by the hash in their filename.
+	packs map[ShaHash]fs.Object
+}
+
+// NewObjectStore creates an ObjectStore for an opened Computer. s may be nil,
+// in which case only loose objects can be found.
+func NewObjectStore(c *Computer, s PackSearcher) *ObjectStore {
+	return &ObjectStore{
+		c:        c,
+		searcher: s,
+	}
+}
+
+type objectReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// GetRaw returns the decrypted contents of the object with hash h, without
+// decompressing them. An object doesn't record how it was compressed, only the
+// commit, tree or node referencing it does, so most callers want Get instead.
+func (s *ObjectStore) GetRaw(ctx context.Context, h ShaHash) (io.ReadCloser, error) {
+	if s.c.enc == nil {
+		return nil, ErrComputerNotOpen
+	}
+	rc, err := s.getLoose(ctx, h)
+	if err == nil {
+		return rc, nil
+	}
+	if !errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, err
+	}
+	return s.getPacked(ctx, h)
+}
+
+func (s *ObjectStore) getLoose(ctx context.Context, h ShaHash) (io.ReadCloser, error) {
+	hStr := h.String()
+	obj, err := s.c.NewObject(ctx, path.Join("objects", hStr[:2], hStr[2:]))
+	if err != nil {
+		return nil, err
+	}
+	rc, err := obj.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &objectReadCloser{
+		Reader: s.c.enc.decryptingReader(rc),
+		Closer: rc,
+	}, nil
+}
+
+func (s *ObjectStore) getPacked(ctx context.Context, h ShaHash) (io.ReadCloser, error) {
+	if s.searcher == nil {
+		return nil, fmt.Errorf("object %s: %w", h, ErrNotFound)
+	}
+	loc, err := s.searcher.Find(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", h, err)
+	}
+	pack, err := s.findPack(ctx, loc.PackHash)
+	if err != nil {
+		return nil, err
+	}
+
+	end := int64(loc.Offset+loc.Length) + maxPackObjectHeaderLen - 1
+	if end >= pack.Size() {
+		end = pack.Size() - 1
+	}
+	rc, err := pack.Open(ctx, &fs.RangeOption{Start: int64(loc.Offset), End: end})
+	if err != nil {
+		return nil, err
+	}
+	var mimetype, name string
+	var length uint64
+	for _, v := range []interface{}{&mimetype, &name, &length} {
+		if err := DecodeArq(rc, v); err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("object %s in pack %s: %w", h, loc.PackHash, err)
+		}
+	}
+	if length != loc.Length {
+		rc.Close()
+		return nil, fmt.Errorf("object %s in pack %s has length %d, expected %d", h, loc.PackHash, length, loc.Length)
+	}
+	return &objectReadCloser{
+		Reader: s.c.enc.decryptingReader(io.LimitReader(rc, int64(length))),
+		Closer: rc,
+	}, nil
+}
+
+func (s *ObjectStore) findPack(ctx context.Context, h ShaHash) (fs.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.packs[h]; ok {
+		return o, nil
+	}
+	// The pack may have been written since we last looked.
+	packs, err := s.listPacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.packs = packs
+	if o, ok := s.packs[h]; ok {
+		return o, nil
+	}
+	return nil, fmt.Errorf("pack %s: %w", h, ErrNotFound)
+}
+
+func (s *ObjectStore) listPacks(ctx context.Context) (map[ShaHash]fs.Object, error) {
+	packsets, err := s.c.ListPacksets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	packs := make(map[ShaHash]fs.Object)
+	for _, ps := range packsets {
+		pfs, err := s.c.ListPacks(ctx, ps)
+		if err != nil {
+			return nil, err
+		}
+		for _, pf := range pfs {
+			if pf.Pack != nil {
+				packs[pf.Hash] = pf.Pack
+			}
+		}
+	}
+	return packs, nil
+}
+
+// Get returns the contents of the object with hash h, decrypted and then
+// decompressed using ct, the CompressionType given by whichever commit, tree
+// or node references the object.
+func (s *ObjectStore) Get(ctx context.Context, h ShaHash, ct CompressionType) (io.ReadCloser, error) {
+	rc, err := s.GetRaw(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := NewDecompressingReader(rc, ct)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("object %s: %w", h, err)
+	}
+	return &objectReadCloser{
+		Reader: dr,
+		Closer: rc,
+	}, nil
+}
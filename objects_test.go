@@ -0,0 +1,100 @@
+package arq_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
+	"github.com/stretchr/testify/assert"
+)
+
+const t1Dir = "testdata/t1/local"
+
+// openT1Computer opens the t1 testdata computer, able to find packed objects.
+func openT1Computer(t *testing.T) *arq.Computer {
+	return t1.OpenComputer(t, t1Dir)
+}
+
+// openT1Folder opens the only folder in the t1 testdata.
+func openT1Folder(t *testing.T) *arq.Folder {
+	return openT1FolderAt(t, t1Dir)
+}
+
+// openT1FolderAt is like openT1Folder, but for a copy of the t1 testdata in
+// dir.
+func openT1FolderAt(t *testing.T, dir string) *arq.Folder {
+	c := t1.OpenComputer(t, dir)
+	if c == nil {
+		return nil
+	}
+	folders, err := c.ListFolders(context.Background())
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	if !assert.Equal(t, 1, len(folders)) {
+		return nil
+	}
+	return folders[0].Folder()
+}
+
+func TestObjectStore(t *testing.T) {
+	ctx := context.Background()
+	c := openT1Computer(t)
+	if c == nil {
+		return
+	}
+	store := c.Objects()
+
+	t.Run("Packed", func(t *testing.T) {
+		h, _ := arq.DecodeShaHashString("917ba67b0748ebbf02f12cdf2b49f536e5ddb20e")
+		rc, err := store.Get(ctx, h, arq.NoneCompression)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer rc.Close()
+		var commit arq.ArqCommit
+		if !assert.Nil(t, arq.DecodeArq(rc, &commit)) {
+			return
+		}
+		assert.Equal(t, 12, commit.Version)
+		assert.Equal(t, 1, len(commit.Parents))
+		assert.Equal(t, "e0534dd4c22365023f8a5e6312903ecbc1afba19", commit.Parents[0].Hash.String())
+	})
+
+	t.Run("Loose", func(t *testing.T) {
+		h, _ := arq.DecodeShaHashString("ac7231f769fbe67c5c47fb0e5d98386b67dc6ea3")
+		rc, err := store.GetRaw(ctx, h)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer rc.Close()
+		by, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, by)
+	})
+
+	t.Run("Decompressed", func(t *testing.T) {
+		expected, err := ioutil.ReadFile("testdata/t1/src/2600-0.txt")
+		if !assert.Nil(t, err) {
+			return
+		}
+		h, _ := arq.DecodeShaHashString("ac7231f769fbe67c5c47fb0e5d98386b67dc6ea3")
+		rc, err := store.Get(ctx, h, arq.Lz4Compression)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer rc.Close()
+		actual, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		h, _ := arq.DecodeShaHashString("0000000000000000000000000000000000000000")
+		_, err := store.Get(ctx, h, arq.NoneCompression)
+		assert.ErrorIs(t, err, arq.ErrNotFound)
+	})
+}
@@ -7,15 +7,11 @@ import (
 	"github.com/sholiday/arq"
 )
 
-type PackLocation struct {
-	Offset   uint64
-	Length   uint64
-	PackHash arq.ShaHash
-}
+type PackLocation = arq.PackLocation
 
 var (
 	ErrAlreadyIndexedPack = errors.New("already indexed this pack index")
-	ErrNotFound           = errors.New("ErrNotFound")
+	ErrNotFound           = arq.ErrNotFound
 )
 
 type Builder interface {
@@ -27,3 +23,8 @@ type Builder interface {
 type Searcher interface {
 	Find(ctx context.Context, h arq.ShaHash) (PackLocation, error)
 }
+
+var (
+	_ arq.PackSearcher     = Searcher(nil)
+	_ arq.PackIndexBuilder = Builder(nil)
+)
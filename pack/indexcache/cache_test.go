@@ -151,6 +151,11 @@ func Run(t *testing.T, nct NewCacheTester) {
 				Length: 100,
 				SHA1:   decodeSha("ff00000000000000000000000000000000000001").Contents,
 			},
+			{
+				Offset: 4000,
+				Length: 100,
+				SHA1:   decodeSha("ffff000000000000000000000000000000000001").Contents,
+			},
 		}
 		piH := decodeSha("2d48a782b4db79027b408ef3d0276ac2d4a8b79b")
 		testWithPacks(t, nct, []pack{{piH, pi}})
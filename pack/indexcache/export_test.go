@@ -0,0 +1,9 @@
+package indexcache
+
+// SetMaxBuildEntries changes how many object locations PersistentCache.Build
+// holds in memory at once, returning a function restoring the old limit.
+func SetMaxBuildEntries(n int) func() {
+	old := maxBuildEntries
+	maxBuildEntries = n
+	return func() { maxBuildEntries = old }
+}
@@ -51,49 +51,86 @@ func (fb *FileBuilder) Build(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	if err := fb.writePacklist(ctx, pList); err != nil {
-		return err
-	}
-	if err := fb.write(ctx, pIndex); err != nil {
-		return err
+	entries := make([]packLocationEntry, 0, len(fb.mp.index))
+	for h, pl := range fb.mp.index {
+		pi, foundPi := pIndex[pl.PackHash]
+		if !foundPi {
+			return fmt.Errorf("couldn't find pack has in pIndex")
+		}
+		entries = append(entries, packLocationEntry{
+			Hash:      h,
+			PackIndex: pi,
+			Offset:    pl.Offset,
+			Length:    pl.Length,
+		})
 	}
-	return nil
+	return writeCache(ctx, fb.workdir, pList, entries)
 }
 
 func (fb *FileBuilder) computePacklist(ctx context.Context) ([]arq.ShaHash, map[arq.ShaHash]uint16, error) {
-	if len(fb.mp.packsets) > math.MaxUint16 {
-		return nil, nil, ErrTooManyPacksets
-	}
-	hIndex := make(map[arq.ShaHash]uint16)
 	packs := make([]arq.ShaHash, 0, len(fb.mp.packsets))
 	for k := range fb.mp.packsets {
 		packs = append(packs, k)
 	}
+	return indexPacklist(packs)
+}
+
+// indexPacklist sorts packs, returning the index of each in the sorted list.
+func indexPacklist(packs []arq.ShaHash) ([]arq.ShaHash, map[arq.ShaHash]uint16, error) {
+	if len(packs) > math.MaxUint16 {
+		return nil, nil, ErrTooManyPacksets
+	}
 	sort.Slice(packs, func(i, j int) bool {
 		return bytes.Compare(packs[i].Contents[:], packs[j].Contents[:]) == -1
 	})
-
+	hIndex := make(map[arq.ShaHash]uint16)
 	for i, h := range packs {
 		hIndex[h] = uint16(i)
 	}
-
 	return packs, hIndex, nil
 }
 
-func (fb *FileBuilder) writePacklist(ctx context.Context, pList []arq.ShaHash) error {
-	w, err := os.OpenFile(path.Join(fb.workdir, packListFname), os.O_RDWR|os.O_CREATE, 0755)
+// writeCache writes the files searched by a FileSearcher into workdir,
+// replacing any already there. The packlist is written last, so that a
+// partially written cache can be recognised by its absence.
+func writeCache(ctx context.Context, workdir string, pList []arq.ShaHash, entries []packLocationEntry) error {
+	if err := os.Remove(path.Join(workdir, packListFname)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sortEntries(entries)
+	if err := writeLocations(ctx, workdir, entries); err != nil {
+		return err
+	}
+	return writePacklist(ctx, workdir, pList)
+}
+
+func sortEntries(entries []packLocationEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Hash.Contents[:], entries[j].Hash.Contents[:]) == -1
+	})
+}
+
+func createFile(fname string) (*os.File, error) {
+	return os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func writePacklist(ctx context.Context, workdir string, pList []arq.ShaHash) error {
+	w, err := createFile(path.Join(workdir, packListFname))
 	if err != nil {
 		return err
 	}
-	defer w.Close()
 	bw := bufio.NewWriter(w)
-	defer bw.Flush()
 	for _, e := range pList {
-		if err := binary.Write(w, binary.BigEndian, e.Contents); err != nil {
+		if err := binary.Write(bw, binary.BigEndian, e.Contents); err != nil {
+			w.Close()
 			return err
 		}
 	}
-	return nil
+	if err := bw.Flush(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
 }
 
 type packLocationEntry struct {
@@ -108,98 +145,125 @@ func (e *packLocationEntry) MarshalLength() int {
 }
 
 func (e *packLocationEntry) MarshalArq(w io.Writer) (int, error) {
-	if err := binary.Write(w, binary.BigEndian, e.Hash.Contents); err != nil {
-		return 0, err
-	}
-	if err := binary.Write(w, binary.BigEndian, e.PackIndex); err != nil {
-		return 0, err
-	}
-	if err := binary.Write(w, binary.BigEndian, e.Offset); err != nil {
-		return 0, err
-	}
-	if err := binary.Write(w, binary.BigEndian, e.Length); err != nil {
-		return 0, err
+	for _, v := range []interface{}{e.Hash.Contents, e.PackIndex, e.Offset, e.Length} {
+		if err := arq.EncodeArq(w, v); err != nil {
+			return 0, err
+		}
 	}
 	return e.MarshalLength(), nil
 }
 
 func (e *packLocationEntry) UnmarshalArq(r io.Reader) error {
-	if err := arq.DecodeArq(r, &e.Hash.Contents); err != nil {
-		return err
+	for _, v := range []interface{}{&e.Hash.Contents, &e.PackIndex, &e.Offset, &e.Length} {
+		if err := arq.DecodeArq(r, v); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if err := binary.Read(r, binary.BigEndian, &e.PackIndex); err != nil {
-		return err
-	}
-	if err := binary.Read(r, binary.BigEndian, &e.Offset); err != nil {
+// writeLocations writes entries, which must be sorted by hash, along with
+// an index of where each two byte prefix of the hashes starts.
+func writeLocations(ctx context.Context, workdir string, entries []packLocationEntry) error {
+	lw, err := newLocationWriter(workdir)
+	if err != nil {
 		return err
 	}
-	if err := binary.Read(r, binary.BigEndian, &e.Length); err != nil {
-		return err
+	for i := range entries {
+		if err := lw.add(&entries[i]); err != nil {
+			lw.abort()
+			return err
+		}
 	}
-	return nil
+	return lw.close()
 }
 
-func (fb *FileBuilder) write(ctx context.Context, pIndex map[arq.ShaHash]uint16) error {
-	hashes := make([]arq.ShaHash, 0, len(fb.mp.index))
-	for k := range fb.mp.index {
-		hashes = append(hashes, k)
-	}
-	sort.Slice(hashes, func(i, j int) bool {
-		return bytes.Compare(hashes[i].Contents[:], hashes[j].Contents[:]) == -1
-	})
+// locationWriter writes the locations of objects, and the index of where each
+// two byte prefix of their hashes starts, as they are added in hash order.
+type locationWriter struct {
+	plW, iW   *os.File
+	bplW, biW *bufio.Writer
+
+	// The next prefix to write to the index, and where it starts.
+	cur int
+	loc uint32
+	// The previous entry added, if any.
+	last    arq.ShaHash
+	hasLast bool
+}
 
-	plW, err := os.OpenFile(path.Join(fb.workdir, packLocationFname), os.O_RDWR|os.O_CREATE, 0755)
+func newLocationWriter(workdir string) (*locationWriter, error) {
+	plW, err := createFile(path.Join(workdir, packLocationFname))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer plW.Close()
-	bplW := bufio.NewWriter(plW)
-	defer bplW.Flush()
-
-	iW, err := os.OpenFile(path.Join(fb.workdir, indexFname), os.O_RDWR|os.O_CREATE, 0755)
+	iW, err := createFile(path.Join(workdir, indexFname))
 	if err != nil {
-		return err
-	}
-	defer iW.Close()
-	biW := bufio.NewWriter(iW)
-	defer biW.Flush()
-
-	cur := uint16(0)
-	loc := uint32(0)
-	for _, h := range hashes {
-		pl := fb.mp.index[h]
-		pi, foundPi := pIndex[pl.PackHash]
-		if !foundPi {
-			return fmt.Errorf("couldn't find pack has in pIndex")
-		}
-		plE := packLocationEntry{
-			Hash:      h,
-			PackIndex: pi,
-			Offset:    pl.Offset,
-			Length:    pl.Length,
-		}
+		plW.Close()
+		return nil, err
+	}
+	return &locationWriter{
+		plW:  plW,
+		iW:   iW,
+		bplW: bufio.NewWriter(plW),
+		biW:  bufio.NewWriter(iW),
+	}, nil
+}
 
-		hPrefix := binary.BigEndian.Uint16(h.Contents[:2])
-		for ; hPrefix >= cur; cur += 1 {
-			if err := binary.Write(biW, binary.BigEndian, loc); err != nil {
-				return err
-			}
+// add writes e, which mustn't sort before the entries already added.
+func (lw *locationWriter) add(e *packLocationEntry) error {
+	if lw.hasLast {
+		switch bytes.Compare(e.Hash.Contents[:], lw.last.Contents[:]) {
+		case 0:
+			// The same object in more than one pack, either will do.
+			return nil
+		case -1:
+			return fmt.Errorf("location of %s added after %s", e.Hash, lw.last)
 		}
+	}
+	lw.last, lw.hasLast = e.Hash, true
 
-		if _, err := plE.MarshalArq(bplW); err != nil {
+	hPrefix := int(binary.BigEndian.Uint16(e.Hash.Contents[:2]))
+	for ; hPrefix >= lw.cur; lw.cur += 1 {
+		if err := binary.Write(lw.biW, binary.BigEndian, lw.loc); err != nil {
 			return err
 		}
-		loc += uint32(plE.MarshalLength())
 	}
-	// Now write out the remaining indexes.
-	for ; math.MaxUint16 > cur; cur += 1 {
-		if err := binary.Write(biW, binary.BigEndian, loc); err != nil {
+
+	if _, err := e.MarshalArq(lw.bplW); err != nil {
+		return err
+	}
+	lw.loc += uint32(e.MarshalLength())
+	return nil
+}
+
+// close writes the rest of the index and closes the files.
+func (lw *locationWriter) close() error {
+	defer lw.abort()
+	// Now write out the remaining indexes, and one more so that every prefix
+	// has an entry following it.
+	for ; lw.cur <= math.MaxUint16+1; lw.cur += 1 {
+		if err := binary.Write(lw.biW, binary.BigEndian, lw.loc); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	if err := lw.bplW.Flush(); err != nil {
+		return err
+	}
+	if err := lw.biW.Flush(); err != nil {
+		return err
+	}
+	if err := lw.plW.Close(); err != nil {
+		return err
+	}
+	return lw.iW.Close()
+}
+
+// abort closes the files without finishing them.
+func (lw *locationWriter) abort() {
+	lw.plW.Close()
+	lw.iW.Close()
 }
 
 func NewFileSearcher(workdir string) *FileSearcher {
@@ -262,8 +326,6 @@ func (fs *FileSearcher) findInIndex(ctx context.Context, h arq.ShaHash) (packLoc
 
 	// Read two uint32s.
 	by := make([]byte, 4+4)
-	// TODO(sholiday): Handle when we're accessing the last possible prefix (like 0xFF).
-	// In that case there will be no offset to follow it.
 	if _, err := fs.indexF.ReadAt(by, int64(prefix)*4); err != nil {
 		return lo, fmt.Errorf("findInIndex %w", err)
 	}
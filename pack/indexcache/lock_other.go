@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package indexcache
+
+import "os"
+
+// lockFile opens fname, creating it if needed. File locks aren't supported
+// here, so nothing stops another process opening the same cache.
+func lockFile(fname string) (*os.File, error) {
+	return os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+}
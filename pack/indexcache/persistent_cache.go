@@ -0,0 +1,371 @@
+package indexcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/sholiday/arq"
+)
+
+var (
+	ErrNotBuilt = errors.New("cache has not been built since pack indexes were added or removed")
+	ErrLocked   = errors.New("cache is in use by another process")
+)
+
+// Directory within a PersistentCache's workdir holding a file for each pack
+// index it has ingested.
+const packsDirname = "packs"
+
+// File within a PersistentCache's workdir which is locked while it's open.
+const lockFname = "lock"
+
+// The most object locations Build holds in memory at once. Larger caches are
+// built in several passes over the ingested pack indexes, each handling a
+// range of hashes.
+var maxBuildEntries = 1 << 20
+
+// PersistentCache is a Builder and Searcher which keeps what it has ingested
+// on disk, so that it can be reopened later and only the pack indexes added
+// or removed since need handling.
+//
+// Each pack index ingested is stored as its own file, containing just the
+// location of each of its objects. Build merges these into the files searched
+// by a FileSearcher, only when the set of pack indexes has changed.
+//
+// Only one PersistentCache can have a workdir open at a time, even in
+// different processes, on platforms supporting file locks.
+type PersistentCache struct {
+	workdir string
+	lock    *os.File
+
+	mu    sync.RWMutex
+	packs map[arq.ShaHash]bool
+	// Whether the searcher reflects the current set of packs.
+	built    bool
+	searcher *FileSearcher
+}
+
+// OpenPersistentCache opens the cache in workdir, creating it if it doesn't
+// exist. A cache should only hold the pack indexes of a single computer. If
+// the cache is already open, ErrLocked is returned.
+func OpenPersistentCache(ctx context.Context, workdir string) (*PersistentCache, error) {
+	if err := os.MkdirAll(path.Join(workdir, packsDirname), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(path.Join(workdir, lockFname))
+	if err != nil {
+		return nil, err
+	}
+	c, err := openPersistentCache(ctx, workdir)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	c.lock = lock
+	return c, nil
+}
+
+func openPersistentCache(ctx context.Context, workdir string) (*PersistentCache, error) {
+	c := &PersistentCache{
+		workdir: workdir,
+		packs:   make(map[arq.ShaHash]bool),
+	}
+	infos, err := ioutil.ReadDir(path.Join(workdir, packsDirname))
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		h, err := arq.DecodeShaHashString(info.Name())
+		if err != nil {
+			// Left over from an interrupted AddPackIndex.
+			os.Remove(path.Join(workdir, packsDirname, info.Name()))
+			continue
+		}
+		c.packs[h] = true
+	}
+
+	built, ok, err := c.readPacklist()
+	if err != nil {
+		return nil, err
+	}
+	if ok && len(built) == len(c.packs) {
+		c.built = true
+		for _, h := range built {
+			if !c.packs[h] {
+				c.built = false
+				break
+			}
+		}
+	}
+	if c.built {
+		c.searcher = NewFileSearcher(workdir)
+		if err := c.searcher.Open(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readPacklist returns the packs the search files were last built from, and
+// false if they weren't completely written.
+func (c *PersistentCache) readPacklist() ([]arq.ShaHash, bool, error) {
+	f, err := os.Open(path.Join(c.workdir, packListFname))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var packs []arq.ShaHash
+	for {
+		var h arq.ShaHash
+		if _, err := io.ReadFull(r, h.Contents[:]); err == io.EOF {
+			return packs, true, nil
+		} else if err != nil {
+			return nil, false, nil
+		}
+		packs = append(packs, h)
+	}
+}
+
+func (c *PersistentCache) packFname(h arq.ShaHash) string {
+	return path.Join(c.workdir, packsDirname, h.String())
+}
+
+func (c *PersistentCache) HasPackIndex(ctx context.Context, h arq.ShaHash) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.packs[h], nil
+}
+
+// PackIndexes returns the hash of every pack index in the cache, sorted.
+func (c *PersistentCache) PackIndexes(ctx context.Context) ([]arq.ShaHash, error) {
+	c.mu.RLock()
+	packs := make([]arq.ShaHash, 0, len(c.packs))
+	for h := range c.packs {
+		packs = append(packs, h)
+	}
+	c.mu.RUnlock()
+	packs, _, err := indexPacklist(packs)
+	return packs, err
+}
+
+func (c *PersistentCache) AddPackIndex(ctx context.Context, h arq.ShaHash, pi arq.ArqPackIndex) error {
+	if has, _ := c.HasPackIndex(ctx, h); has {
+		return ErrAlreadyIndexedPack
+	}
+
+	// Write to a temporary file first, so that a pack is never half
+	// ingested.
+	f, err := ioutil.TempFile(path.Join(c.workdir, packsDirname), "tmp")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, o := range pi.Objects {
+		e := packLocationEntry{
+			Hash:   arq.WrapShaHash(&o.SHA1),
+			Offset: o.Offset,
+			Length: o.Length,
+		}
+		if _, err := e.MarshalArq(bw); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	err = bw.Flush()
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.packFname(h))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packs[h] = true
+	c.built = false
+	return nil
+}
+
+// RemovePackIndex removes the objects of a pack index, typically because the
+// pack has been deleted.
+func (c *PersistentCache) RemovePackIndex(ctx context.Context, h arq.ShaHash) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.packs[h] {
+		return nil
+	}
+	if err := os.Remove(c.packFname(h)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(c.packs, h)
+	c.built = false
+	return nil
+}
+
+// Build rewrites the search files if pack indexes have been added or removed
+// since they were last built.
+func (c *PersistentCache) Build(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.built {
+		return nil
+	}
+	if c.searcher != nil {
+		c.searcher.Close()
+		c.searcher = nil
+	}
+
+	packs := make([]arq.ShaHash, 0, len(c.packs))
+	for h := range c.packs {
+		packs = append(packs, h)
+	}
+	pList, pIndex, err := indexPacklist(packs)
+	if err != nil {
+		return err
+	}
+	if err := c.writeLocations(ctx, pList, pIndex); err != nil {
+		return err
+	}
+	if err := writePacklist(ctx, c.workdir, pList); err != nil {
+		return err
+	}
+
+	searcher := NewFileSearcher(c.workdir)
+	if err := searcher.Open(ctx); err != nil {
+		return err
+	}
+	c.searcher = searcher
+	c.built = true
+	return nil
+}
+
+// writeLocations writes the search files from the ingested pack indexes,
+// without holding more than maxBuildEntries of their objects in memory. The
+// packlist is removed first, so that it's only present once the cache is
+// completely written.
+func (c *PersistentCache) writeLocations(ctx context.Context, pList []arq.ShaHash, pIndex map[arq.ShaHash]uint16) error {
+	if err := os.Remove(path.Join(c.workdir, packListFname)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var total int64
+	for _, h := range pList {
+		info, err := os.Stat(c.packFname(h))
+		if err != nil {
+			return err
+		}
+		total += info.Size() / int64((&packLocationEntry{}).MarshalLength())
+	}
+	passes := int(total/int64(maxBuildEntries)) + 1
+
+	lw, err := newLocationWriter(c.workdir)
+	if err != nil {
+		return err
+	}
+	var entries []packLocationEntry
+	for p := 0; p < passes; p++ {
+		// Each pass handles the objects whose first two bytes fall in a
+		// range, hashes are evenly spread so they each hold about as many.
+		lo := p * (math.MaxUint16 + 1) / passes
+		hi := (p + 1) * (math.MaxUint16 + 1) / passes
+		entries = entries[:0]
+		for _, h := range pList {
+			if err := ctx.Err(); err != nil {
+				lw.abort()
+				return err
+			}
+			if entries, err = c.readPack(h, pIndex[h], lo, hi, entries); err != nil {
+				lw.abort()
+				return err
+			}
+		}
+		sortEntries(entries)
+		for i := range entries {
+			if err := lw.add(&entries[i]); err != nil {
+				lw.abort()
+				return err
+			}
+		}
+	}
+	return lw.close()
+}
+
+// readPack appends the entries of an ingested pack index whose hashes have a
+// two byte prefix from lo up to, but not including, hi to entries.
+func (c *PersistentCache) readPack(h arq.ShaHash, pi uint16, lo, hi int, entries []packLocationEntry) ([]packLocationEntry, error) {
+	f, err := os.Open(c.packFname(h))
+	if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		var e packLocationEntry
+		if _, err := io.ReadFull(r, e.Hash.Contents[:]); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &e.PackIndex); err != nil {
+			return entries, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &e.Offset); err != nil {
+			return entries, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &e.Length); err != nil {
+			return entries, err
+		}
+		if prefix := int(binary.BigEndian.Uint16(e.Hash.Contents[:2])); prefix < lo || prefix >= hi {
+			continue
+		}
+		e.PackIndex = pi
+		entries = append(entries, e)
+	}
+}
+
+func (c *PersistentCache) Find(ctx context.Context, h arq.ShaHash) (PackLocation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.built {
+		return PackLocation{}, ErrNotBuilt
+	}
+	return c.searcher.Find(ctx, h)
+}
+
+// Close closes the search files and unlocks the cache. Everything ingested
+// has already been saved.
+func (c *PersistentCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.searcher != nil {
+		c.searcher.Close()
+		c.searcher = nil
+	}
+	c.built = false
+	if c.lock != nil {
+		c.lock.Close()
+		c.lock = nil
+	}
+}
+
+var (
+	_ Builder              = &PersistentCache{}
+	_ Searcher             = &PersistentCache{}
+	_ arq.PackIndexRemover = &PersistentCache{}
+)
@@ -0,0 +1,139 @@
+package indexcache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/pack/indexcache"
+	"github.com/stretchr/testify/assert"
+)
+
+type pcCacheTester struct {
+	c *indexcache.PersistentCache
+}
+
+func (ct pcCacheTester) Builder() indexcache.Builder {
+	return ct.c
+}
+
+func (ct pcCacheTester) Searcher() indexcache.Searcher {
+	return ct.c
+}
+
+func (ct pcCacheTester) Close() {
+	ct.c.Close()
+}
+
+func newPCCacheTester(t *testing.T) cacheTester {
+	c, err := indexcache.OpenPersistentCache(context.Background(), t.TempDir())
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	return &pcCacheTester{c}
+}
+
+func TestPersistentCache(t *testing.T) {
+	Run(t, newPCCacheTester)
+}
+
+func TestPersistentCacheReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	pi1 := loadPackIndex(t, "../../testdata/types/1.index")
+	if pi1 == nil {
+		return
+	}
+	h1 := arq.WrapShaHash(&pi1.SHA1)
+	h2 := decodeSha("2d48a782b4db79027b408ef3d0276ac2d4a8b79b")
+	var pi2 arq.ArqPackIndex
+	pi2.Objects = []arq.ArqPackIndexObject{
+		{
+			Offset: 1000,
+			Length: 100,
+			SHA1:   decodeSha("aa00000000000000000000000000000000000001").Contents,
+		},
+	}
+	o1 := arq.WrapShaHash(&pi1.Objects[0].SHA1)
+	o2 := arq.WrapShaHash(&pi2.Objects[0].SHA1)
+
+	c, err := indexcache.OpenPersistentCache(ctx, dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, c.AddPackIndex(ctx, h1, *pi1))
+	assert.Nil(t, c.AddPackIndex(ctx, h2, pi2))
+	assert.ErrorIs(t, c.AddPackIndex(ctx, h2, pi2), indexcache.ErrAlreadyIndexedPack)
+	_, err = c.Find(ctx, o1)
+	assert.ErrorIs(t, err, indexcache.ErrNotBuilt)
+	if !assert.Nil(t, c.Build(ctx)) {
+		return
+	}
+	c.Close()
+
+	// Reopened, it can be searched without building again.
+	c, err = indexcache.OpenPersistentCache(ctx, dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, h := range []arq.ShaHash{h1, h2} {
+		has, err := c.HasPackIndex(ctx, h)
+		assert.Nil(t, err)
+		assert.True(t, has)
+	}
+	packs, err := c.PackIndexes(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []arq.ShaHash{h2, h1}, packs)
+	l, err := c.Find(ctx, o2)
+	if assert.Nil(t, err) {
+		assert.Equal(t, h2, l.PackHash)
+		assert.Equal(t, uint64(1000), l.Offset)
+	}
+
+	// Dropping a pack removes its objects, once built.
+	assert.Nil(t, c.RemovePackIndex(ctx, h2))
+	if !assert.Nil(t, c.Build(ctx)) {
+		return
+	}
+	_, err = c.Find(ctx, o2)
+	assert.ErrorIs(t, err, indexcache.ErrNotFound)
+	l, err = c.Find(ctx, o1)
+	if assert.Nil(t, err) {
+		assert.Equal(t, h1, l.PackHash)
+	}
+	c.Close()
+
+	c, err = indexcache.OpenPersistentCache(ctx, dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	has, err := c.HasPackIndex(ctx, h2)
+	assert.Nil(t, err)
+	assert.False(t, has)
+	_, err = c.Find(ctx, o1)
+	assert.Nil(t, err)
+}
+
+func TestPersistentCacheSeveralPasses(t *testing.T) {
+	defer indexcache.SetMaxBuildEntries(2)()
+	Run(t, newPCCacheTester)
+}
+
+func TestPersistentCacheLock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c, err := indexcache.OpenPersistentCache(ctx, dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = indexcache.OpenPersistentCache(ctx, dir)
+	assert.ErrorIs(t, err, indexcache.ErrLocked)
+	c.Close()
+
+	c, err = indexcache.OpenPersistentCache(ctx, dir)
+	if assert.Nil(t, err) {
+		c.Close()
+	}
+}
@@ -0,0 +1,165 @@
+package arq
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rclone/rclone/fs"
+)
+
+var arqPackIndexMagic = []byte{0xff, 0x74, 0x4f, 0x63}
+
+// Refuse to read objects larger than this from a pack, Arq splits files into
+// much smaller chunks.
+const maxPackObjectLen = 1 << 30
+
+// RebuildPackIndex recreates the index of a pack, for when it has been lost
+// or is corrupt, by reading through the pack one object at a time.
+//
+// An object's hash is the SHA1 of its decompressed contents, keyed by the
+// computer's encryption keys, but a pack doesn't record how each object was
+// compressed. Contents which happen to look compressed may not be, so each
+// object is listed under the hash of every CompressionType its contents can
+// be decoded as, including none. The extra entries are never looked up, and
+// are harmless to Arq. The pack's own SHA1 trailer is checked too.
+func (c *Computer) RebuildPackIndex(ctx context.Context, pack fs.Object) (*ArqPackIndex, error) {
+	if c.enc == nil {
+		return nil, ErrComputerNotOpen
+	}
+	rc, err := pack.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	pi, err := rebuildPackIndex(bufio.NewReader(rc), c.enc)
+	if err != nil {
+		return nil, fmt.Errorf("pack %s: %w", pack.Remote(), err)
+	}
+	return pi, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func rebuildPackIndex(input io.Reader, enc objectCipher) (*ArqPackIndex, error) {
+	h := sha1.New()
+	r := &countingReader{r: io.TeeReader(input, h)}
+
+	var header struct {
+		Magic       [4]byte
+		Version     uint32
+		ObjectCount uint64
+	}
+	if err := DecodeArq(r, &header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header.Magic[:], []byte("PACK")) {
+		return nil, fmt.Errorf("magic bytes '% x' are incorrect for ArqPack", header.Magic)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("invalid version '%d' for ArqPack", header.Version)
+	}
+
+	pi := &ArqPackIndex{Version: 2}
+	copy(pi.Header[:], arqPackIndexMagic)
+	for i := uint64(0); i < header.ObjectCount; i++ {
+		offset := r.n
+		var mimetype, name string
+		var length uint64
+		for _, v := range []interface{}{&mimetype, &name, &length} {
+			if err := DecodeArq(r, v); err != nil {
+				return nil, fmt.Errorf("object %d: %w", i, err)
+			}
+		}
+		if length > maxPackObjectLen {
+			return nil, fmt.Errorf("object %d has length %d: %w", i, length, ErrTooLong)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("object %d: %w", i, err)
+		}
+		hashes, err := objectHashes(data, enc)
+		if err != nil {
+			return nil, fmt.Errorf("object %d at offset %d: %w", i, offset, err)
+		}
+		for _, oh := range hashes {
+			pi.Objects = append(pi.Objects, ArqPackIndexObject{
+				Offset: uint64(offset),
+				Length: length,
+				SHA1:   oh.Contents,
+			})
+		}
+	}
+
+	calculated := h.Sum(nil)
+	var sum [20]byte
+	if err := DecodeArq(input, &sum); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(calculated, sum[:]) {
+		return nil, fmt.Errorf("ArqPack checksum '%x' doesn't match calculated '%x'", sum[:], calculated)
+	}
+
+	sort.Slice(pi.Objects, func(i, j int) bool {
+		return bytes.Compare(pi.Objects[i].SHA1[:], pi.Objects[j].SHA1[:]) == -1
+	})
+	pi.setFanout()
+	return pi, nil
+}
+
+// objectHashes decrypts an object and returns every hash it may be stored
+// under: that of its contents as they are, and of each way they can be
+// decompressed.
+func objectHashes(data []byte, enc objectCipher) ([]ShaHash, error) {
+	plain, err := io.ReadAll(enc.decryptingReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	hashes := []ShaHash{enc.objectHash(plain)}
+	for _, out := range decompressCandidates(plain) {
+		h := enc.objectHash(out)
+		if h != hashes[0] {
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes, nil
+}
+
+// decompressCandidates returns the decompressed contents of an object whose
+// CompressionType isn't known, for each of Gzip and LZ4 that they are valid
+// data for.
+func decompressCandidates(data []byte) [][]byte {
+	var out [][]byte
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		if zr, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+			if by, err := io.ReadAll(zr); err == nil {
+				out = append(out, by)
+			}
+		}
+	}
+	// LZ4 can't compress by more than a factor of 255, don't allocate a
+	// buffer for anything that couldn't be LZ4.
+	if len(data) >= 4 && uint64(binary.BigEndian.Uint32(data)) <= 255*uint64(len(data)) {
+		if lr, err := newLz4Reader(bytes.NewReader(data)); err == nil {
+			if by, err := io.ReadAll(lr); err == nil {
+				out = append(out, by)
+			}
+		}
+	}
+	return out
+}
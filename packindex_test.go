@@ -0,0 +1,88 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebuildPackIndex(t *testing.T) {
+	ctx := context.Background()
+	c := openT1Computer(t)
+	if c == nil {
+		return
+	}
+	packsets, err := c.ListPacksets(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	n := 0
+	for _, ps := range packsets {
+		packs, err := c.ListPacks(ctx, ps)
+		if !assert.Nil(t, err) {
+			return
+		}
+		for _, pf := range packs {
+			n++
+			pi, err := c.RebuildPackIndex(ctx, pf.Pack)
+			if !assert.Nil(t, err, pf.Hash) {
+				continue
+			}
+			var buf bytes.Buffer
+			_, err = pi.MarshalArq(&buf)
+			assert.Nil(t, err)
+			var decoded arq.ArqPackIndex
+			assert.Nil(t, arq.DecodeArq(&buf, &decoded))
+
+			// Every object in the index Arq wrote is in the rebuilt one,
+			// which also lists compressed objects under the hash of their
+			// compressed contents.
+			by, err := ioutil.ReadFile(filepath.Join(t1Dir, pf.Index.Remote()))
+			if !assert.Nil(t, err) {
+				continue
+			}
+			var expected arq.ArqPackIndex
+			if !assert.Nil(t, arq.DecodeArq(bytes.NewReader(by), &expected)) {
+				continue
+			}
+			for _, o := range expected.Objects {
+				assert.Contains(t, decoded.Objects, o, pf.Hash)
+			}
+			assert.GreaterOrEqual(t, len(decoded.Objects), len(expected.Objects))
+			assert.LessOrEqual(t, len(decoded.Objects), 2*len(expected.Objects))
+		}
+	}
+	assert.Equal(t, 6, n)
+}
+
+func TestRebuildPackIndexCorrupt(t *testing.T) {
+	ctx := context.Background()
+	dir := copyT1(t)
+	packs, err := filepath.Glob(filepath.Join(dir, "*/packsets/*-trees/*.pack"))
+	if !assert.Nil(t, err) || !assert.NotEmpty(t, packs) {
+		return
+	}
+	flipByte(t, packs[0])
+	c := t1.NewComputer(t, dir)
+	if c == nil {
+		return
+	}
+	pfs, err := c.ListPacks(ctx, "9084C9D4-B59E-4F94-A577-CF5FCFF23056-trees")
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, pf := range pfs {
+		if filepath.Base(packs[0]) == pf.Hash.String()+".pack" {
+			_, err := c.RebuildPackIndex(ctx, pf.Pack)
+			assert.NotNil(t, err)
+			return
+		}
+	}
+	t.Errorf("didn't find %s", packs[0])
+}
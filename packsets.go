@@ -0,0 +1,302 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// The number of pack indexes IndexPacks fetches at once by default.
+const defaultIndexConcurrency = 8
+
+// PackIndexBuilder ingests pack indexes, to build something which can find
+// the pack an object is stored in. It is satisfied by any
+// indexcache.Builder.
+type PackIndexBuilder interface {
+	HasPackIndex(ctx context.Context, h ShaHash) (bool, error)
+	AddPackIndex(ctx context.Context, h ShaHash, pi ArqPackIndex) error
+	Build(ctx context.Context) error
+}
+
+// PackIndexRemover is implemented by PackIndexBuilders which persist what
+// they have ingested, so that IndexPacks can drop the indexes of packs which
+// have since been deleted.
+type PackIndexRemover interface {
+	PackIndexes(ctx context.Context) ([]ShaHash, error)
+	RemovePackIndex(ctx context.Context, h ShaHash) error
+}
+
+// PackFile is a pack in a packset, along with its index. Either may be nil if
+// it is missing.
+type PackFile struct {
+	Packset string
+	Hash    ShaHash
+	Pack    fs.Object
+	Index   fs.Object
+}
+
+// ListPacksets returns the names of the packsets under `packsets/`. Each
+// folder has two, named for its bucket UUID: `<uuid>-trees` and
+// `<uuid>-blobs`.
+func (c *Computer) ListPacksets(ctx context.Context) ([]string, error) {
+	entries, err := c.List(ctx, "packsets")
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var packsets []string
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Directory); ok {
+			packsets = append(packsets, path.Base(entry.Remote()))
+		}
+	}
+	return packsets, nil
+}
+
+// ListPacks returns every pack and pack index in a packset, in the order the
+// remote lists them.
+func (c *Computer) ListPacks(ctx context.Context, packset string) ([]PackFile, error) {
+	entries, err := c.List(ctx, path.Join("packsets", packset))
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var packs []*PackFile
+	byHash := make(map[ShaHash]*PackFile)
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok {
+			continue
+		}
+		fName := path.Base(o.Remote())
+		ext := path.Ext(fName)
+		if ext != ".pack" && ext != ".index" {
+			continue
+		}
+		h, err := DecodeShaHashString(strings.TrimSuffix(fName, ext))
+		if err != nil {
+			continue
+		}
+		pf, ok := byHash[h]
+		if !ok {
+			pf = &PackFile{Packset: packset, Hash: h}
+			byHash[h] = pf
+			packs = append(packs, pf)
+		}
+		if ext == ".pack" {
+			pf.Pack = o
+		} else {
+			pf.Index = o
+		}
+	}
+	out := make([]PackFile, len(packs))
+	for i, pf := range packs {
+		out[i] = *pf
+	}
+	return out, nil
+}
+
+// Packsets returns the names of the folder's packsets.
+func (f *Folder) Packsets() []string {
+	return []string{f.uuid + "-trees", f.uuid + "-blobs"}
+}
+
+type IndexPacksOptions struct {
+	// The packsets to index, or every packset of the computer if empty.
+	Packsets []string
+	// How many pack indexes to fetch at once, defaultIndexConcurrency if 0.
+	Concurrency int
+	// RebuildIndexes rebuilds the index of any pack whose index is missing or
+	// corrupt, using RebuildPackIndex, rather than leaving it out or failing.
+	// The computer must be opened.
+	RebuildIndexes bool
+}
+
+// IndexPacksReport describes what IndexPacks did.
+type IndexPacksReport struct {
+	// The number of pack indexes ingested, and those which the builder
+	// already had.
+	Added    int
+	Existing int
+	// The number of pack indexes dropped from the builder because their pack
+	// no longer exists.
+	Removed int
+	// Packs without an index. Their objects can't be found.
+	StrayPacks []PackFile
+	// Packs whose index was missing or corrupt, and had it rebuilt.
+	Rebuilt []PackFile
+}
+
+// IndexPacks feeds every pack index in the computer's packsets, that b
+// doesn't have already, into b and then builds it. If b is also a
+// PackSearcher, it becomes the computer's PackSearcher, so that objects in
+// packs can be found.
+//
+// Pack indexes are fetched concurrently, but b is only called by one
+// goroutine at a time. If b is a PackIndexRemover and every packset is being
+// indexed, the indexes of packs that no longer exist are removed from it.
+//
+// Pack indexes aren't encrypted, so the computer needn't be opened unless
+// they are to be rebuilt.
+func (c *Computer) IndexPacks(ctx context.Context, b PackIndexBuilder, opts *IndexPacksOptions) (*IndexPacksReport, error) {
+	if opts == nil {
+		opts = &IndexPacksOptions{}
+	}
+	packsets := opts.Packsets
+	if len(packsets) == 0 {
+		var err error
+		if packsets, err = c.ListPacksets(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &IndexPacksReport{}
+	found := make(map[ShaHash]bool)
+	var toAdd []PackFile
+	for _, ps := range packsets {
+		packs, err := c.ListPacks(ctx, ps)
+		if err != nil {
+			return nil, err
+		}
+		for _, pf := range packs {
+			if pf.Index == nil && !(opts.RebuildIndexes && pf.Pack != nil) {
+				report.StrayPacks = append(report.StrayPacks, pf)
+				continue
+			}
+			found[pf.Hash] = true
+			has, err := b.HasPackIndex(ctx, pf.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if has {
+				report.Existing++
+				continue
+			}
+			toAdd = append(toAdd, pf)
+		}
+	}
+
+	rebuilt, err := c.addPackIndexes(ctx, b, toAdd, opts)
+	if err != nil {
+		return nil, err
+	}
+	report.Added = len(toAdd)
+	report.Rebuilt = rebuilt
+
+	if r, ok := b.(PackIndexRemover); ok && len(opts.Packsets) == 0 {
+		have, err := r.PackIndexes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range have {
+			if found[h] {
+				continue
+			}
+			if err := r.RemovePackIndex(ctx, h); err != nil {
+				return nil, err
+			}
+			report.Removed++
+		}
+	}
+
+	if err := b.Build(ctx); err != nil {
+		return nil, err
+	}
+	if s, ok := b.(PackSearcher); ok {
+		c.SetPackSearcher(s)
+	}
+	return report, nil
+}
+
+// addPackIndexes fetches and decodes the index of each pack, with up to
+// opts.Concurrency at once, adding each to b. It returns the packs whose
+// index had to be rebuilt.
+func (c *Computer) addPackIndexes(ctx context.Context, b PackIndexBuilder, packs []PackFile, opts *IndexPacksOptions) ([]PackFile, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultIndexConcurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan PackFile)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	var rebuilt []PackFile
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pf := range jobs {
+				pi, wasRebuilt, err := c.loadPackIndex(ctx, pf, opts.RebuildIndexes)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				err = b.AddPackIndex(ctx, pf.Hash, *pi)
+				if wasRebuilt {
+					rebuilt = append(rebuilt, pf)
+				}
+				mu.Unlock()
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+	for _, pf := range packs {
+		select {
+		case jobs <- pf:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return rebuilt, ctx.Err()
+}
+
+// loadPackIndex decodes the index of pf, or if rebuild is set and the index
+// is missing or corrupt, rebuilds it from the pack.
+func (c *Computer) loadPackIndex(ctx context.Context, pf PackFile, rebuild bool) (*ArqPackIndex, bool, error) {
+	var err error
+	if pf.Index != nil {
+		var pi ArqPackIndex
+		if err = decodeObject(ctx, pf.Index, &pi); err == nil {
+			return &pi, false, nil
+		}
+		err = fmt.Errorf("pack index %s: %w", pf.Index.Remote(), err)
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+	}
+	if !rebuild || pf.Pack == nil {
+		return nil, false, err
+	}
+	pi, err := c.RebuildPackIndex(ctx, pf.Pack)
+	return pi, err == nil, err
+}
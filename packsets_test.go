@@ -0,0 +1,147 @@
+package arq_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/sholiday/arq/internal/t1"
+	"github.com/sholiday/arq/pack/indexcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexPacks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Searchable", func(t *testing.T) {
+		c := t1.NewComputer(t, t1Dir)
+		if c == nil {
+			return
+		}
+		packsets, err := c.ListPacksets(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"9084C9D4-B59E-4F94-A577-CF5FCFF23056-blobs",
+			"9084C9D4-B59E-4F94-A577-CF5FCFF23056-trees",
+		}, packsets)
+
+		report, err := c.IndexPacks(ctx, indexcache.NewMapBackedCache(), &arq.IndexPacksOptions{Concurrency: 2})
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 6, report.Added)
+		assert.Empty(t, report.StrayPacks)
+
+		// The commit is stored in a pack.
+		h, _ := arq.DecodeShaHashString("917ba67b0748ebbf02f12cdf2b49f536e5ddb20e")
+		rc, err := c.Objects().GetRaw(ctx, h)
+		if assert.Nil(t, err) {
+			rc.Close()
+		}
+	})
+
+	t.Run("Folder", func(t *testing.T) {
+		c := t1.NewComputer(t, t1Dir)
+		if c == nil {
+			return
+		}
+		folders, err := c.ListFolders(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		report, err := c.IndexPacks(ctx, indexcache.NewMapBackedCache(), &arq.IndexPacksOptions{
+			Packsets: folders[0].Folder().Packsets()[:1],
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 3, report.Added)
+	})
+
+	t.Run("Incremental", func(t *testing.T) {
+		dir := copyT1(t)
+		c := t1.NewComputer(t, dir)
+		if c == nil {
+			return
+		}
+		cache, err := indexcache.OpenPersistentCache(ctx, t.TempDir())
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer cache.Close()
+		report, err := c.IndexPacks(ctx, cache, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 6, report.Added)
+
+		// Delete one pack entirely, and only the index of another.
+		blobs := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7/packsets/9084C9D4-B59E-4F94-A577-CF5FCFF23056-blobs")
+		for _, fname := range []string{
+			"122fb9fbb279f63353ed1a2d175433411a0a0d65.index",
+			"122fb9fbb279f63353ed1a2d175433411a0a0d65.pack",
+			"549f5232e59c5b9b077a61834a2d376d1956661a.index",
+		} {
+			if !assert.Nil(t, os.Remove(filepath.Join(blobs, fname))) {
+				return
+			}
+		}
+		report, err = c.IndexPacks(ctx, cache, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 0, report.Added)
+		assert.Equal(t, 4, report.Existing)
+		assert.Equal(t, 2, report.Removed)
+		if assert.Equal(t, 1, len(report.StrayPacks)) {
+			assert.Equal(t, "549f5232e59c5b9b077a61834a2d376d1956661a", report.StrayPacks[0].Hash.String())
+		}
+		packs, err := cache.PackIndexes(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(packs))
+	})
+
+	t.Run("Rebuild", func(t *testing.T) {
+		dir := copyT1(t)
+		trees := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7/packsets/9084C9D4-B59E-4F94-A577-CF5FCFF23056-trees")
+		fnames, err := filepath.Glob(filepath.Join(trees, "*.index"))
+		if !assert.Nil(t, err) {
+			return
+		}
+		// Lose one index, and corrupt another.
+		if !assert.Nil(t, os.Remove(fnames[0])) {
+			return
+		}
+		flipByte(t, fnames[1])
+		c := t1.NewComputer(t, dir)
+		if c == nil {
+			return
+		}
+
+		_, err = c.IndexPacks(ctx, indexcache.NewMapBackedCache(), nil)
+		assert.NotNil(t, err)
+
+		report, err := c.IndexPacks(ctx, indexcache.NewMapBackedCache(), &arq.IndexPacksOptions{RebuildIndexes: true})
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 6, report.Added)
+		assert.Equal(t, 2, len(report.Rebuilt))
+		assert.Empty(t, report.StrayPacks)
+
+		// Every commit can be found.
+		for _, s := range []string{
+			"917ba67b0748ebbf02f12cdf2b49f536e5ddb20e",
+			"e0534dd4c22365023f8a5e6312903ecbc1afba19",
+			"0ed92a2ab71b2fe75a28fcd785e1c9ec51e040f2",
+		} {
+			h, _ := arq.DecodeShaHashString(s)
+			rc, err := c.Objects().GetRaw(ctx, h)
+			if assert.Nil(t, err, s) {
+				rc.Close()
+			}
+		}
+	})
+}
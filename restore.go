@@ -0,0 +1,280 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Unix file type bits from st_mode, as stored in ArqNode.Mode.
+const (
+	unixTypeMask    = 0170000
+	unixTypeDir     = 0040000
+	unixTypeRegular = 0100000
+	unixTypeSymlink = 0120000
+)
+
+// IsSymlink reports whether the node is a symbolic link, in which case its
+// data is the link's target.
+func (n *ArqNode) IsSymlink() bool {
+	return n.Mode&unixTypeMask == unixTypeSymlink
+}
+
+// IsRegular reports whether the node is a regular file.
+func (n *ArqNode) IsRegular() bool {
+	return !n.IsTree && n.Mode&unixTypeMask == unixTypeRegular
+}
+
+// FileMode converts the node's Unix mode into an os.FileMode.
+func (n *ArqNode) FileMode() os.FileMode {
+	return unixToFileMode(n.Mode)
+}
+
+func unixToFileMode(mode int32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	switch mode & unixTypeMask {
+	case unixTypeDir:
+		m |= os.ModeDir
+	case unixTypeSymlink:
+		m |= os.ModeSymlink
+	}
+	return m
+}
+
+type RestoreOptions struct {
+	// Overwrite replaces files which already exist in the destination.
+	Overwrite bool
+	// Ownership restores each node's Uid and Gid. Failures due to a lack of
+	// permission are ignored.
+	Ownership bool
+	// XAttrs restores each node's extended attributes, which is only
+	// supported on Linux. Names without a namespace, like those from macOS,
+	// are restored in the "user." namespace. Symlinks' attributes are
+	// skipped, as Linux doesn't allow them.
+	XAttrs bool
+}
+
+type restoredDir struct {
+	target string
+	node   *ArqNode
+}
+
+// Restore recreates the files, directories and symlinks from commit inside
+// destDir. Other file types, like devices and FIFOs, are skipped.
+func Restore(ctx context.Context, f *Folder, commit *ArqCommit, destDir string, opts RestoreOptions) error {
+	destDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	var dirs []restoredDir
+	err = f.Walk(ctx, commit, func(p string, node *ArqNode, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, filepath.FromSlash(p))
+		if !strings.HasPrefix(target, destDir+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to restore '%s' outside of '%s'", p, destDir)
+		}
+		switch {
+		case node.IsTree:
+			if err := f.LoadDirMetadata(ctx, node); err != nil {
+				return fmt.Errorf("restoring '%s': %w", p, err)
+			}
+			// Keep the directory writable until its contents are restored.
+			if err := restoreDir(target, opts); err != nil {
+				return fmt.Errorf("restoring '%s': %w", p, err)
+			}
+			dirs = append(dirs, restoredDir{target, node})
+			return nil
+		case node.IsSymlink():
+			if err := restoreSymlink(ctx, f, target, node, opts); err != nil {
+				return fmt.Errorf("restoring '%s': %w", p, err)
+			}
+		case node.IsRegular():
+			if err := restoreFile(ctx, f, target, node, opts); err != nil {
+				return fmt.Errorf("restoring '%s': %w", p, err)
+			}
+		default:
+			return nil
+		}
+		return restoreMetadata(ctx, f, target, node, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Directories are finished last, deepest first, so that restoring their
+	// contents doesn't change their mtime.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMetadata(ctx, f, dirs[i].target, dirs[i].node, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyNodeData writes the decompressed contents of every data chunk in node to
+// w, returning the number of bytes written.
+func (f *Folder) CopyNodeData(ctx context.Context, w io.Writer, node *ArqNode) (int64, error) {
+	var written int64
+	for _, bk := range node.DataBlobKeys {
+		rc, err := f.computer.Objects().Get(ctx, bk.Hash, node.DataCompressionType)
+		if err != nil {
+			return written, err
+		}
+		n, err := io.Copy(w, rc)
+		rc.Close()
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// restoreDir creates the directory target, or reuses it if it already exists.
+// Anything else in its place is an error, or with opts.Overwrite is removed,
+// so that a symlink can't lead the restore outside of the destination.
+func restoreDir(target string, opts RestoreOptions) error {
+	err := os.Mkdir(target, 0700)
+	if err == nil || !os.IsExist(err) {
+		return err
+	}
+	fi, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	if !opts.Overwrite {
+		return fmt.Errorf("'%s' exists and isn't a directory", target)
+	}
+	if err := os.Remove(target); err != nil {
+		return err
+	}
+	return os.Mkdir(target, 0700)
+}
+
+// removeSymlink removes target if it's a symlink, so that it's replaced
+// rather than followed.
+func removeSymlink(target string) error {
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(target)
+}
+
+func restoreFile(ctx context.Context, f *Folder, target string, node *ArqNode, opts RestoreOptions) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL | oNoFollow
+	if opts.Overwrite {
+		if err := removeSymlink(target); err != nil {
+			return err
+		}
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC | oNoFollow
+	}
+	out, err := os.OpenFile(target, flags, 0600)
+	if err != nil {
+		return err
+	}
+	rc, err := f.OpenNode(ctx, node, nil)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer rc.Close()
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// The longest symlink target restored, PATH_MAX on Linux.
+const maxSymlinkLen = 4096
+
+func restoreSymlink(ctx context.Context, f *Folder, target string, node *ArqNode, opts RestoreOptions) error {
+	if node.DataSize > maxSymlinkLen {
+		return fmt.Errorf("symlink target is %d bytes: %w", node.DataSize, ErrTooLong)
+	}
+	rc, err := f.OpenNode(ctx, node, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, io.LimitReader(rc, int64(node.DataSize)+1)); err != nil {
+		return err
+	}
+	if uint64(sb.Len()) != node.DataSize {
+		return fmt.Errorf("symlink target has %d bytes of data, expected %d", sb.Len(), node.DataSize)
+	}
+	if opts.Overwrite {
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Symlink(sb.String(), target)
+}
+
+func restoreMetadata(ctx context.Context, f *Folder, target string, node *ArqNode, opts RestoreOptions) error {
+	if opts.Ownership {
+		err := os.Lchown(target, int(node.Uid), int(node.Gid))
+		if err != nil && !errors.Is(err, syscall.EPERM) {
+			return err
+		}
+	}
+	// Symlinks have no permissions of their own, and changing their times
+	// would follow the link.
+	if node.IsSymlink() {
+		return nil
+	}
+	// Before chmod, which may remove the write permission setting them needs.
+	if opts.XAttrs {
+		if err := restoreXAttrs(ctx, f, target, node); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(target, node.FileMode()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, node.Mtime, node.Mtime)
+}
+
+func restoreXAttrs(ctx context.Context, f *Folder, target string, node *ArqNode) error {
+	attrs, err := f.XAttrs(ctx, node)
+	if err != nil {
+		return err
+	}
+	for _, name := range attrs.Names() {
+		if err := setXAttr(target, name, attrs[name]); err != nil {
+			return fmt.Errorf("setting xattr '%s': %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package arq_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	master, err := f.FindMaster(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	commit, err := f.Commit(ctx, master)
+	if !assert.Nil(t, err) {
+		return
+	}
+	tdir, err := ioutil.TempDir("", "arqrestore")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(tdir)
+
+	if !assert.Nil(t, arq.Restore(ctx, f, commit, tdir, arq.RestoreOptions{})) {
+		return
+	}
+
+	for _, p := range []string{"2600-0.txt", "one.txt", "somedir/two.txt"} {
+		expected, err := ioutil.ReadFile(filepath.Join("testdata/t1/src", p))
+		if !assert.Nil(t, err) {
+			continue
+		}
+		actual, err := ioutil.ReadFile(filepath.Join(tdir, p))
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Equal(t, expected, actual, p)
+	}
+
+	err = f.Walk(ctx, commit, func(p string, node *arq.ArqNode, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := f.LoadDirMetadata(ctx, node); err != nil {
+			return err
+		}
+		fi, err := os.Lstat(filepath.Join(tdir, p))
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		assert.Equal(t, node.FileMode(), fi.Mode(), p)
+		assert.True(t, node.Mtime.Equal(fi.ModTime()), p)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	t.Run("Exists", func(t *testing.T) {
+		assert.NotNil(t, arq.Restore(ctx, f, commit, tdir, arq.RestoreOptions{}))
+		assert.Nil(t, arq.Restore(ctx, f, commit, tdir, arq.RestoreOptions{Overwrite: true}))
+	})
+
+	t.Run("Symlinks", func(t *testing.T) {
+		dest := t.TempDir()
+		outside := t.TempDir()
+		outsideFile := filepath.Join(outside, "file")
+		if !assert.Nil(t, ioutil.WriteFile(outsideFile, []byte("outside"), 0644)) {
+			return
+		}
+		if !assert.Nil(t, os.Symlink(outside, filepath.Join(dest, "somedir"))) ||
+			!assert.Nil(t, os.Symlink(outsideFile, filepath.Join(dest, "one.txt"))) {
+			return
+		}
+
+		assert.NotNil(t, arq.Restore(ctx, f, commit, dest, arq.RestoreOptions{}))
+		if !assert.Nil(t, arq.Restore(ctx, f, commit, dest, arq.RestoreOptions{Overwrite: true})) {
+			return
+		}
+		for _, p := range []string{"somedir", "one.txt"} {
+			fi, err := os.Lstat(filepath.Join(dest, p))
+			if assert.Nil(t, err) {
+				assert.Zero(t, fi.Mode()&os.ModeSymlink, p)
+			}
+		}
+		by, err := ioutil.ReadFile(outsideFile)
+		assert.Nil(t, err)
+		assert.Equal(t, "outside", string(by))
+		_, err = os.Stat(filepath.Join(outside, "two.txt"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
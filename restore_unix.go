@@ -0,0 +1,9 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package arq
+
+import "syscall"
+
+// Makes opening a file fail if it's a symlink, rather than following it.
+const oNoFollow = syscall.O_NOFOLLOW
@@ -0,0 +1,183 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Reflog entries are named for the number of seconds between the start of
+// 2001, Core Foundation's reference date, and when they were written.
+var cfReferenceDate = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Time returns when the reflog entry was written, shortly after its commit.
+func (r RefListEntry) Time() time.Time {
+	return cfReferenceDate.Add(time.Duration(r.Name) * time.Second)
+}
+
+// SnapshotStatus is whether a Snapshot can still be restored.
+type SnapshotStatus int
+
+const (
+	// The commit is reachable from the master head and can be read.
+	SnapshotCurrent SnapshotStatus = iota
+	// The commit can be read, but is only in the reflog: a later backup
+	// rewrote the history, so following parents from the master head no
+	// longer leads to it. It is the old head of a reflog entry marked as a
+	// rewrite, or one of that head's parents.
+	SnapshotRewritten
+	// The commit no longer exists, usually because Arq removed it to stay
+	// within its budget.
+	SnapshotMissing
+	// The commit can be read, but is only in the reflog, and no rewrite
+	// explains why following parents from the master head doesn't lead to
+	// it.
+	SnapshotUnreachable
+)
+
+func (s SnapshotStatus) String() string {
+	switch s {
+	case SnapshotCurrent:
+		return "current"
+	case SnapshotRewritten:
+		return "rewritten"
+	case SnapshotMissing:
+		return "missing"
+	case SnapshotUnreachable:
+		return "unreachable"
+	default:
+		return fmt.Sprintf("SnapshotStatus(%d)", int(s))
+	}
+}
+
+// Snapshot is a backup point of a folder, a commit found from the master head
+// or the reflog.
+type Snapshot struct {
+	Hash   ShaHash
+	Status SnapshotStatus
+	// The commit, nil if Status is SnapshotMissing.
+	Commit *ArqCommit
+	// The reflog entry which made the commit the head, and its name, or nil
+	// if the commit is only known from another's parents.
+	Ref     *RefEntry
+	RefName int
+	// The commit's CreationDate, or if it is missing, when its reflog entry
+	// was written. Zero if neither is known.
+	Date time.Time
+	// Why the commit is missing.
+	Err error
+}
+
+// Snapshots returns every backup point of the folder, most recent first.
+//
+// It follows the parents of each commit from the master head, stopping at
+// commits which are missing, then adds the commits in the reflog which
+// weren't found that way, which are missing, rewritten or unreachable.
+// Errors other than a missing commit are returned.
+func (f *Folder) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	seen := make(map[ShaHash]int)
+	add := func(h ShaHash, status SnapshotStatus) (*Snapshot, error) {
+		s := Snapshot{Hash: h, Status: status}
+		commit, err := f.Commit(ctx, h)
+		switch {
+		case err == nil:
+			s.Commit = commit
+			s.Date = commit.CreationDate
+		case errors.Is(err, ErrNotFound) || errors.Is(err, fs.ErrorObjectNotFound):
+			s.Status = SnapshotMissing
+			s.Err = err
+		default:
+			return nil, err
+		}
+		seen[h] = len(snapshots)
+		snapshots = append(snapshots, s)
+		return &snapshots[len(snapshots)-1], nil
+	}
+
+	master, err := f.FindMaster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for queue := []ShaHash{master}; len(queue) > 0; {
+		h := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[h]; ok || h == (ShaHash{}) {
+			continue
+		}
+		s, err := add(h, SnapshotCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if s.Commit == nil {
+			continue
+		}
+		for _, p := range s.Commit.Parents {
+			queue = append(queue, p.Hash)
+		}
+	}
+
+	refs, err := f.ListRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The heads which rewrites replaced.
+	var rewritten []ShaHash
+	for _, ref := range refs {
+		re, err := f.RefEntry(ctx, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		h, err := DecodeShaHashString(re.NewHeadSha1)
+		if err != nil {
+			return nil, fmt.Errorf("reflog entry %d: %w", ref.Name, err)
+		}
+		if re.IsRewrite && re.OldHeadSha1 != "" {
+			old, err := DecodeShaHashString(re.OldHeadSha1)
+			if err != nil {
+				return nil, fmt.Errorf("reflog entry %d: %w", ref.Name, err)
+			}
+			rewritten = append(rewritten, old)
+		}
+		i, ok := seen[h]
+		if !ok {
+			if _, err := add(h, SnapshotUnreachable); err != nil {
+				return nil, err
+			}
+			i = len(snapshots) - 1
+		}
+		s := &snapshots[i]
+		// Only the earliest entry to make the commit the head, as a rewrite
+		// may make an existing commit the head again.
+		if s.Ref == nil || ref.Name < s.RefName {
+			s.Ref = &re
+			s.RefName = ref.Name
+			if s.Commit == nil {
+				s.Date = ref.Time()
+			}
+		}
+	}
+
+	// The replaced heads, and their parents which the master head no longer
+	// leads to, were rewritten.
+	for queue := rewritten; len(queue) > 0; {
+		i, ok := seen[queue[0]]
+		queue = queue[1:]
+		if !ok || snapshots[i].Status != SnapshotUnreachable {
+			continue
+		}
+		snapshots[i].Status = SnapshotRewritten
+		for _, p := range snapshots[i].Commit.Parents {
+			queue = append(queue, p.Hash)
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Date.After(snapshots[j].Date)
+	})
+	return snapshots, nil
+}
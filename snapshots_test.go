@@ -0,0 +1,145 @@
+package arq_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	t1Commit1 = "0ed92a2ab71b2fe75a28fcd785e1c9ec51e040f2"
+	t1Commit2 = "e0534dd4c22365023f8a5e6312903ecbc1afba19"
+	t1Commit3 = "917ba67b0748ebbf02f12cdf2b49f536e5ddb20e"
+)
+
+// writeRefEntry adds an entry to the reflog of the t1 folder in dir.
+func writeRefEntry(t *testing.T, dir string, name int, oldHead, newHead string, isRewrite bool) {
+	p := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7", "bucketdata", "9084C9D4-B59E-4F94-A577-CF5FCFF23056", "refs", "logs", "master", fmt.Sprint(name))
+	writeFile(t, p, []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>oldHeadSHA1</key>
+	<string>%s</string>
+	<key>newHeadSHA1</key>
+	<string>%s</string>
+	<key>isRewrite</key>
+	<%t/>
+</dict>
+</plist>`, oldHead, newHead, isRewrite)))
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	type expectation struct {
+		hash    string
+		status  arq.SnapshotStatus
+		refName int
+	}
+	check := func(t *testing.T, f *arq.Folder, expected []expectation) {
+		snapshots, err := f.Snapshots(ctx)
+		if !assert.Nil(t, err) || !assert.Equal(t, len(expected), len(snapshots)) {
+			return
+		}
+		for i, e := range expected {
+			s := snapshots[i]
+			assert.Equal(t, e.hash, s.Hash.String(), i)
+			assert.Equal(t, e.status, s.Status, e.hash)
+			assert.Equal(t, e.refName, s.RefName, e.hash)
+			assert.False(t, s.Date.IsZero(), e.hash)
+			if e.refName != 0 && assert.NotNil(t, s.Ref, e.hash) {
+				assert.Equal(t, e.hash, s.Ref.NewHeadSha1)
+			}
+			if e.status == arq.SnapshotMissing {
+				assert.Nil(t, s.Commit)
+				assert.ErrorIs(t, s.Err, arq.ErrNotFound)
+			} else {
+				assert.Nil(t, s.Err)
+				assert.Equal(t, s.Date, s.Commit.CreationDate)
+			}
+		}
+	}
+
+	t.Run("T1", func(t *testing.T) {
+		f := openT1Folder(t)
+		if f == nil {
+			return
+		}
+		check(t, f, []expectation{
+			{t1Commit3, arq.SnapshotCurrent, 644364918},
+			{t1Commit2, arq.SnapshotCurrent, 643889878},
+			{t1Commit1, arq.SnapshotCurrent, 642078900},
+		})
+	})
+
+	t.Run("Rewritten", func(t *testing.T) {
+		dir := copyT1(t)
+		// Roll the head back to the second commit, leaving the third only in
+		// the reflog, and add an entry for a commit which has since been
+		// removed.
+		master := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7", "bucketdata", "9084C9D4-B59E-4F94-A577-CF5FCFF23056", "refs", "heads", "master")
+		writeFile(t, master, []byte(t1Commit2+"Y"))
+		writeRefEntry(t, dir, 644400000, t1Commit3, t1Commit2, true)
+		writeRefEntry(t, dir, 643000000, t1Commit1, "0123456789abcdef0123456789abcdef01234567", false)
+
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		check(t, f, []expectation{
+			{t1Commit3, arq.SnapshotRewritten, 644364918},
+			// Its earliest reflog entry, not the rewrite.
+			{t1Commit2, arq.SnapshotCurrent, 643889878},
+			{"0123456789abcdef0123456789abcdef01234567", arq.SnapshotMissing, 643000000},
+			{t1Commit1, arq.SnapshotCurrent, 642078900},
+		})
+	})
+
+	t.Run("RewrittenParents", func(t *testing.T) {
+		dir := copyT1(t)
+		// Roll the head back to the first commit, which replaces the third
+		// and its parent, the second.
+		master := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7", "bucketdata", "9084C9D4-B59E-4F94-A577-CF5FCFF23056", "refs", "heads", "master")
+		writeFile(t, master, []byte(t1Commit1+"Y"))
+		writeRefEntry(t, dir, 644400000, t1Commit3, t1Commit1, true)
+
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		check(t, f, []expectation{
+			{t1Commit3, arq.SnapshotRewritten, 644364918},
+			{t1Commit2, arq.SnapshotRewritten, 643889878},
+			{t1Commit1, arq.SnapshotCurrent, 642078900},
+		})
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		dir := copyT1(t)
+		// Roll the head back to the second commit, but without a reflog
+		// entry saying it was a rewrite.
+		master := filepath.Join(dir, "8C10C697-7DCA-4747-B92B-6900CC64CCE7", "bucketdata", "9084C9D4-B59E-4F94-A577-CF5FCFF23056", "refs", "heads", "master")
+		writeFile(t, master, []byte(t1Commit2+"Y"))
+		writeRefEntry(t, dir, 644400000, t1Commit3, t1Commit2, false)
+
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		check(t, f, []expectation{
+			{t1Commit3, arq.SnapshotUnreachable, 644364918},
+			{t1Commit2, arq.SnapshotCurrent, 643889878},
+			{t1Commit1, arq.SnapshotCurrent, 642078900},
+		})
+	})
+}
+
+func TestRefListEntryTime(t *testing.T) {
+	e := arq.RefListEntry{Name: 644364918}
+	assert.Equal(t, time.Date(2021, 6, 2, 22, 15, 18, 0, time.UTC), e.Time().UTC())
+}
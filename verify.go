@@ -0,0 +1,378 @@
+package arq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// VerifyProblemKind describes what is wrong with an object.
+type VerifyProblemKind int
+
+const (
+	// The object couldn't be found in a pack or in `objects/`.
+	VerifyMissing VerifyProblemKind = iota
+	// The object was found, but couldn't be decrypted, decompressed or
+	// decoded, or its checksum didn't match.
+	VerifyCorrupt
+)
+
+func (k VerifyProblemKind) String() string {
+	switch k {
+	case VerifyMissing:
+		return "missing"
+	case VerifyCorrupt:
+		return "corrupt"
+	default:
+		return "INVALID"
+	}
+}
+
+// VerifyProblem is a missing or corrupt object found by Folder.Verify.
+type VerifyProblem struct {
+	Kind VerifyProblemKind
+	// What the object is: "commit", "tree", "data", "xattrs", "acl", "pack"
+	// or "pack index".
+	Type string
+	// Hash of the object. For packs and pack indexes it's the hash in their
+	// filename.
+	Hash ShaHash
+	// The commit and path within it which first referenced the object. Path
+	// is empty for commits and the root tree, and is the location of the file
+	// on the remote for packs and pack indexes.
+	Commit ShaHash
+	Path   string
+	Err    error
+}
+
+func (p VerifyProblem) String() string {
+	return fmt.Sprintf("%s %s %s: %v", p.Kind, p.Type, p.Hash, p.Err)
+}
+
+// VerifyReport is the result of Folder.Verify.
+type VerifyReport struct {
+	// The number of distinct commits, trees, blobs (data, xattrs and ACLs)
+	// and packs checked.
+	Commits int
+	Trees   int
+	Blobs   int
+	Packs   int
+
+	Problems []VerifyProblem
+}
+
+// OK returns whether every object was present and intact.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks that every commit in the folder's reflog can be restored.
+//
+// Every pack belonging to the folder is read, checking the SHA1 trailers of
+// both the pack and its index. Then every object reachable from each commit
+// is fetched in full, which checks its HMAC, and decompressed and decoded.
+// Each object is only checked once, however many commits reference it, and a
+// problem is reported for the first reference found.
+//
+// Parents of the commits aren't followed, Arq removes old commits from the
+// reflog when it deletes them to stay within a budget.
+//
+// Problems with the backup are collected in the report, an error is only
+// returned if the verification itself couldn't continue.
+func (f *Folder) Verify(ctx context.Context) (*VerifyReport, error) {
+	v := &verifier{
+		f:      f,
+		seen:   make(map[ShaHash]int64),
+		report: &VerifyReport{},
+	}
+	if err := v.verifyPacks(ctx); err != nil {
+		return nil, err
+	}
+	refs, err := f.ListRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		re, err := f.RefEntry(ctx, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		h, err := DecodeShaHashString(re.NewHeadSha1)
+		if err != nil {
+			return nil, fmt.Errorf("reflog entry %d: %w", ref.Name, err)
+		}
+		if err := v.verifyCommit(ctx, h); err != nil {
+			return nil, err
+		}
+	}
+	return v.report, nil
+}
+
+type verifier struct {
+	f      *Folder
+	report *VerifyReport
+	// Every object checked so far, with its decompressed length, or -1 if
+	// there was a problem with it.
+	seen map[ShaHash]int64
+}
+
+// verifyPacks checks every pack and pack index in the folder's packsets.
+func (v *verifier) verifyPacks(ctx context.Context) error {
+	for _, ps := range v.f.Packsets() {
+		packs, err := v.f.computer.ListPacks(ctx, ps)
+		if err != nil {
+			return err
+		}
+		dir := path.Join("packsets", ps)
+		for _, pf := range packs {
+			if pf.Pack != nil {
+				v.report.Packs++
+				if err := checkPackObject(ctx, pf.Pack); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					v.problem(VerifyCorrupt, "pack", pf.Hash, ShaHash{}, pf.Pack.Remote(), err)
+				}
+			} else {
+				v.problem(VerifyMissing, "pack", pf.Hash, ShaHash{}, path.Join(dir, pf.Hash.String()+".pack"), ErrNotFound)
+			}
+			if pf.Index != nil {
+				var pi ArqPackIndex
+				if err := decodeObject(ctx, pf.Index, &pi); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					v.problem(VerifyCorrupt, "pack index", pf.Hash, ShaHash{}, pf.Index.Remote(), err)
+				}
+			} else {
+				v.problem(VerifyMissing, "pack index", pf.Hash, ShaHash{}, path.Join(dir, pf.Hash.String()+".index"), ErrNotFound)
+			}
+		}
+	}
+	return nil
+}
+
+func checkPackObject(ctx context.Context, o fs.Object) error {
+	rc, err := o.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return checkPack(bufio.NewReader(rc))
+}
+
+// checkPack reads through a pack one object at a time, checking its structure
+// and SHA1 trailer without keeping the objects' data.
+func checkPack(input io.Reader) error {
+	h := sha1.New()
+	r := io.TeeReader(input, h)
+
+	var header struct {
+		Magic       [4]byte
+		Version     uint32
+		ObjectCount uint64
+	}
+	if err := DecodeArq(r, &header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header.Magic[:], []byte("PACK")) {
+		return fmt.Errorf("magic bytes '% x' are incorrect for ArqPack", header.Magic)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("invalid version '%d' for ArqPack", header.Version)
+	}
+	for i := uint64(0); i < header.ObjectCount; i++ {
+		var mimetype, name string
+		var length uint64
+		for _, v := range []interface{}{&mimetype, &name, &length} {
+			if err := DecodeArq(r, v); err != nil {
+				return fmt.Errorf("object %d: %w", i, err)
+			}
+		}
+		if length > maxPackObjectLen {
+			return fmt.Errorf("object %d has length %d: %w", i, length, ErrTooLong)
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("object %d: %w", i, err)
+		}
+	}
+
+	calculated := h.Sum(nil)
+	var sum [20]byte
+	if err := DecodeArq(input, &sum); err != nil {
+		return err
+	}
+	if !bytes.Equal(calculated, sum[:]) {
+		return fmt.Errorf("ArqPack checksum '%x' doesn't match calculated '%x'", sum[:], calculated)
+	}
+	return nil
+}
+
+func decodeObject(ctx context.Context, o fs.Object, v interface{}) error {
+	rc, err := o.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return DecodeArq(rc, v)
+}
+
+func (v *verifier) verifyCommit(ctx context.Context, h ShaHash) error {
+	if _, ok := v.seen[h]; ok {
+		return nil
+	}
+	v.report.Commits++
+	by, err := v.fetch(ctx, "commit", h, NoneCompression, h, "")
+	if err != nil || by == nil {
+		return err
+	}
+	var commit ArqCommit
+	if err := DecodeArq(bytes.NewReader(by), &commit); err != nil {
+		v.corrupt("commit", h, h, "", err)
+		return nil
+	}
+	return v.verifyTree(ctx, h, "", commit.TreeBlobKey.Hash, commit.TreeCompressionType)
+}
+
+func (v *verifier) verifyTree(ctx context.Context, commit ShaHash, dir string, h ShaHash, ct CompressionType) error {
+	if _, ok := v.seen[h]; ok {
+		// Unchanged subtrees are shared between commits, and have already
+		// been checked in full.
+		return nil
+	}
+	v.report.Trees++
+	by, err := v.fetch(ctx, "tree", h, ct, commit, dir)
+	if err != nil || by == nil {
+		return err
+	}
+	var t ArqTree
+	if err := DecodeArq(bytes.NewReader(by), &t); err != nil {
+		v.corrupt("tree", h, commit, dir, err)
+		return nil
+	}
+	if err := v.verifyBlob(ctx, "xattrs", t.XattrsBlobKey.Hash, t.XattrsCompressionType, commit, dir); err != nil {
+		return err
+	}
+	if err := v.verifyBlob(ctx, "acl", t.AclBlobKey.Hash, t.AclCompressionType, commit, dir); err != nil {
+		return err
+	}
+
+	for i := range t.Nodes {
+		p := path.Join(dir, t.Nodes[i].FileName)
+		node := &t.Nodes[i].Node
+		if node.IsTree {
+			if len(node.DataBlobKeys) != 1 {
+				v.corrupt("tree", h, commit, dir, fmt.Errorf("tree node '%s' has %d blob keys, expected 1", p, len(node.DataBlobKeys)))
+				continue
+			}
+			if err := v.verifyTree(ctx, commit, p, node.DataBlobKeys[0].Hash, node.DataCompressionType); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := v.verifyBlob(ctx, "xattrs", node.XattrsBlobKey.Hash, node.XattrsCompressionType, commit, p); err != nil {
+			return err
+		}
+		if err := v.verifyBlob(ctx, "acl", node.AclBlobKey.Hash, node.AclCompressionType, commit, p); err != nil {
+			return err
+		}
+		var size int64
+		for _, bk := range node.DataBlobKeys {
+			if err := v.verifyBlob(ctx, "data", bk.Hash, node.DataCompressionType, commit, p); err != nil {
+				return err
+			}
+			if size >= 0 && v.seen[bk.Hash] >= 0 {
+				size += v.seen[bk.Hash]
+			} else {
+				size = -1
+			}
+		}
+		if size >= 0 && uint64(size) != node.DataSize {
+			v.corrupt("tree", h, commit, p, fmt.Errorf("'%s' has %d bytes of data, expected %d", p, size, node.DataSize))
+		}
+	}
+	return nil
+}
+
+// verifyBlob checks a data, xattrs or ACL blob. Nodes without xattrs or an ACL
+// have an empty hash.
+func (v *verifier) verifyBlob(ctx context.Context, typ string, h ShaHash, ct CompressionType, commit ShaHash, p string) error {
+	if h == (ShaHash{}) {
+		return nil
+	}
+	if _, ok := v.seen[h]; ok {
+		return nil
+	}
+	v.report.Blobs++
+	// Blobs are only measured, not decoded, so aren't kept in memory.
+	_, err := v.copyObject(ctx, io.Discard, typ, h, ct, commit, p)
+	return err
+}
+
+// fetch reads the whole of a commit or tree, so that its HMAC is checked, and
+// decompresses it. Problems are added to the report, and nil returned. An
+// error is only returned if ctx is done.
+func (v *verifier) fetch(ctx context.Context, typ string, h ShaHash, ct CompressionType, commit ShaHash, p string) ([]byte, error) {
+	var buf bytes.Buffer
+	ok, err := v.copyObject(ctx, &buf, typ, h, ct, commit, p)
+	if !ok {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		// Empty, rather than nil, so that it isn't mistaken for a problem.
+		return []byte{}, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// copyObject is like fetch, but decompresses the object into w, and returns
+// whether it could be read.
+func (v *verifier) copyObject(ctx context.Context, w io.Writer, typ string, h ShaHash, ct CompressionType, commit ShaHash, p string) (bool, error) {
+	v.seen[h] = -1
+	rc, err := v.f.computer.Objects().Get(ctx, h, ct)
+	if err == nil {
+		var n int64
+		n, err = io.Copy(w, rc)
+		rc.Close()
+		if err == nil {
+			v.seen[h] = n
+			return true, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, fs.ErrorObjectNotFound) {
+		v.problem(VerifyMissing, typ, h, commit, p, err)
+	} else {
+		v.corrupt(typ, h, commit, p, err)
+	}
+	return false, nil
+}
+
+func (v *verifier) corrupt(typ string, h ShaHash, commit ShaHash, p string, err error) {
+	v.seen[h] = -1
+	v.problem(VerifyCorrupt, typ, h, commit, p, err)
+}
+
+func (v *verifier) problem(kind VerifyProblemKind, typ string, h ShaHash, commit ShaHash, p string, err error) {
+	v.report.Problems = append(v.report.Problems, VerifyProblem{
+		Kind:   kind,
+		Type:   typ,
+		Hash:   h,
+		Commit: commit,
+		Path:   p,
+		Err:    err,
+	})
+}
@@ -0,0 +1,201 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+const t1LooseObject = "8C10C697-7DCA-4747-B92B-6900CC64CCE7/objects/ac/7231f769fbe67c5c47fb0e5d98386b67dc6ea3"
+
+// copyT1 copies the t1 testdata into a temporary directory, so it can be
+// damaged.
+func copyT1(t *testing.T) string {
+	dir := t.TempDir()
+	err := filepath.Walk(t1Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(t1Dir, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		by, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, rel), by, 0644)
+	})
+	assert.Nil(t, err)
+	return dir
+}
+
+// flipByte inverts the byte in the middle of the file at p.
+func flipByte(t *testing.T, p string) {
+	by, err := ioutil.ReadFile(p)
+	if !assert.Nil(t, err) {
+		return
+	}
+	by[len(by)/2] ^= 0xff
+	assert.Nil(t, ioutil.WriteFile(p, by, 0644))
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("OK", func(t *testing.T) {
+		f := openT1Folder(t)
+		if f == nil {
+			return
+		}
+		report, err := f.Verify(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.True(t, report.OK(), "%v", report.Problems)
+		assert.Equal(t, 3, report.Commits)
+		assert.Equal(t, 6, report.Packs)
+		assert.NotZero(t, report.Trees)
+		assert.NotZero(t, report.Blobs)
+	})
+
+	t.Run("MissingObject", func(t *testing.T) {
+		dir := copyT1(t)
+		if !assert.Nil(t, os.Remove(filepath.Join(dir, t1LooseObject))) {
+			return
+		}
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		report, err := f.Verify(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		if !assert.Equal(t, 1, len(report.Problems), "%v", report.Problems) {
+			return
+		}
+		p := report.Problems[0]
+		assert.Equal(t, arq.VerifyMissing, p.Kind)
+		assert.Equal(t, "data", p.Type)
+		assert.Equal(t, "ac7231f769fbe67c5c47fb0e5d98386b67dc6ea3", p.Hash.String())
+		assert.Equal(t, "2600-0.txt", p.Path)
+	})
+
+	t.Run("CorruptObject", func(t *testing.T) {
+		dir := copyT1(t)
+		flipByte(t, filepath.Join(dir, t1LooseObject))
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		report, err := f.Verify(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		if !assert.Equal(t, 1, len(report.Problems), "%v", report.Problems) {
+			return
+		}
+		p := report.Problems[0]
+		assert.Equal(t, arq.VerifyCorrupt, p.Kind)
+		assert.Equal(t, "data", p.Type)
+		assert.Equal(t, "ac7231f769fbe67c5c47fb0e5d98386b67dc6ea3", p.Hash.String())
+	})
+
+	t.Run("WrongSize", func(t *testing.T) {
+		// Replace the data of 2600-0.txt with a valid object which is too
+		// short.
+		dir := copyT1(t)
+		writeT1Object(t, dir, "ac7231f769fbe67c5c47fb0e5d98386b67dc6ea3", lz4Frame(t, []byte("too short")))
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		report, err := f.Verify(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		// Reported for the tree of each commit containing the file.
+		if !assert.Equal(t, 2, len(report.Problems), "%v", report.Problems) {
+			return
+		}
+		for _, p := range report.Problems {
+			assert.Equal(t, arq.VerifyCorrupt, p.Kind)
+			assert.Equal(t, "tree", p.Type)
+			assert.Contains(t, p.Err.Error(), "'2600-0.txt' has 9 bytes of data, expected 3359584")
+		}
+	})
+
+	t.Run("LargePackObject", func(t *testing.T) {
+		dir := copyT1(t)
+		sets, err := filepath.Glob(filepath.Join(dir, "*/packsets/*-blobs"))
+		if !assert.Nil(t, err) || !assert.Equal(t, 1, len(sets)) {
+			return
+		}
+		// Pack objects are decoded with the slice length limit of 4096, but
+		// may be much larger.
+		pack := arq.ArqPack{
+			Magic:   [4]byte{'P', 'A', 'C', 'K'},
+			Version: 2,
+			Objects: []arq.ArqPackObject{{Data: bytes.Repeat([]byte{0xaa}, 5000)}},
+		}
+		pi := arq.ArqPackIndex{
+			Header:  [4]byte{0xff, 0x74, 0x4f, 0x63},
+			Version: 2,
+			Objects: []arq.ArqPackIndexObject{{Offset: 16, Length: 5000, SHA1: [20]byte{0xaa}}},
+		}
+		for b := 0xaa; b < len(pi.Fanout); b++ {
+			pi.Fanout[b] = 1
+		}
+		name := filepath.Join(sets[0], "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+		for p, v := range map[string]interface{}{name + ".pack": &pack, name + ".index": &pi} {
+			buf := new(bytes.Buffer)
+			if !assert.Nil(t, arq.EncodeArq(buf, v)) || !assert.Nil(t, ioutil.WriteFile(p, buf.Bytes(), 0644)) {
+				return
+			}
+		}
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		report, err := f.Verify(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.True(t, report.OK(), "%v", report.Problems)
+		assert.Equal(t, 7, report.Packs)
+	})
+
+	t.Run("CorruptPack", func(t *testing.T) {
+		dir := copyT1(t)
+		packs, err := filepath.Glob(filepath.Join(dir, "*/packsets/*-trees/*.pack"))
+		if !assert.Nil(t, err) || !assert.NotEmpty(t, packs) {
+			return
+		}
+		flipByte(t, packs[0])
+		f := openT1FolderAt(t, dir)
+		if f == nil {
+			return
+		}
+		report, err := f.Verify(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		if !assert.NotEmpty(t, report.Problems) {
+			return
+		}
+		p := report.Problems[0]
+		assert.Equal(t, arq.VerifyCorrupt, p.Kind)
+		assert.Equal(t, "pack", p.Type)
+		assert.Equal(t, filepath.Base(packs[0]), p.Hash.String()+".pack")
+	})
+}
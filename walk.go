@@ -0,0 +1,238 @@
+package arq
+
+import (
+	"context"
+	"fmt"
+	iofs "io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// SkipDir can be returned by a WalkFunc to skip the directory it was called
+// with.
+var SkipDir = iofs.SkipDir
+
+// WalkFunc is called by Folder.Walk for every node in a commit's tree. p is
+// the path of the node relative to the root of the folder.
+//
+// A directory is passed to WalkFunc before its subtree is loaded, so that
+// returning SkipDir avoids fetching it. Arq only stores the metadata of a
+// directory in its subtree, so a WalkFunc which needs it must call
+// Folder.LoadDirMetadata. If the subtree can't be loaded, WalkFunc is called
+// a second time for the directory with the error, and returning nil continues
+// the walk without it. Other nodes may be shared with other callers, and
+// mustn't be modified.
+type WalkFunc func(p string, node *ArqNode, err error) error
+
+// Commit loads the commit with hash h.
+func (f *Folder) Commit(ctx context.Context, h ShaHash) (*ArqCommit, error) {
+	rc, err := f.computer.Objects().Get(ctx, h, NoneCompression)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var c ArqCommit
+	if err := DecodeArq(rc, &c); err != nil {
+		return nil, fmt.Errorf("commit %s: %w", h, err)
+	}
+	return &c, nil
+}
+
+// Tree loads the tree with hash h, compressed using ct.
+func (f *Folder) Tree(ctx context.Context, h ShaHash, ct CompressionType) (*ArqTree, error) {
+	rc, err := f.computer.Objects().Get(ctx, h, ct)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var t ArqTree
+	if err := DecodeArq(rc, &t); err != nil {
+		return nil, fmt.Errorf("tree %s: %w", h, err)
+	}
+	return &t, nil
+}
+
+// Walk calls fn for every node reachable from the root tree of commit, in
+// lexical order, descending into subtrees as it finds them.
+func (f *Folder) Walk(ctx context.Context, commit *ArqCommit, fn WalkFunc) error {
+	root, err := f.Tree(ctx, commit.TreeBlobKey.Hash, commit.TreeCompressionType)
+	if err != nil {
+		return err
+	}
+	err = f.walkTree(ctx, "", root, fn)
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (f *Folder) walkTree(ctx context.Context, dir string, t *ArqTree, fn WalkFunc) error {
+	for i := range t.Nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := path.Join(dir, t.Nodes[i].FileName)
+		node := &t.Nodes[i].Node
+		if !node.IsTree {
+			if err := fn(p, node, nil); err != nil {
+				if err == SkipDir {
+					// Like filepath.WalkDir, skip the rest of the directory.
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
+		// The tree may be shared, so the directory's metadata is filled into
+		// a copy of its node.
+		dirNode := *node
+		node = &dirNode
+		if err := fn(p, node, nil); err != nil {
+			if err == SkipDir {
+				continue
+			}
+			return err
+		}
+		child, err := f.subtree(ctx, node)
+		if err != nil {
+			if err := fn(p, node, err); err != nil && err != SkipDir {
+				return err
+			}
+			continue
+		}
+		child.copyMetadata(node)
+		if err := f.walkTree(ctx, p, child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup finds the node at path p, relative to the root of commit, only
+// loading the trees along the way.
+func (f *Folder) Lookup(ctx context.Context, commit *ArqCommit, p string) (*ArqNode, error) {
+	node, _, err := f.lookup(ctx, commit, p)
+	return node, err
+}
+
+// lookup is like Lookup, but also returns the subtree of the node, if it's a
+// directory.
+func (f *Folder) lookup(ctx context.Context, commit *ArqCommit, p string) (*ArqNode, *ArqTree, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		return nil, nil, fmt.Errorf("lookup of the root tree is not supported")
+	}
+	t, err := f.Tree(ctx, commit.TreeBlobKey.Hash, commit.TreeCompressionType)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, part := range parts {
+		var node *ArqNode
+		for j := range t.Nodes {
+			if t.Nodes[j].FileName == part {
+				node = &t.Nodes[j].Node
+				break
+			}
+		}
+		if node == nil {
+			return nil, nil, fmt.Errorf("'%s': %w", p, ErrNotFound)
+		}
+		if !node.IsTree {
+			if i != len(parts)-1 {
+				return nil, nil, fmt.Errorf("'%s': %w", p, ErrNotFound)
+			}
+			found := *node
+			return &found, nil, nil
+		}
+		if t, err = f.subtree(ctx, node); err != nil {
+			return nil, nil, err
+		}
+		if i == len(parts)-1 {
+			// A copy, as the tree holding the node may be shared.
+			found := *node
+			t.copyMetadata(&found)
+			return &found, t, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("'%s': %w", p, ErrNotFound)
+}
+
+// The number of subtrees a Folder keeps after loading them.
+const subtreeCacheSize = 4
+
+// subtree loads the tree referenced by node, which must be a tree node. The
+// tree may be shared with other callers, possibly concurrently, and mustn't be
+// modified: copy a node before filling in its metadata.
+func (f *Folder) subtree(ctx context.Context, node *ArqNode) (*ArqTree, error) {
+	if len(node.DataBlobKeys) != 1 {
+		return nil, fmt.Errorf("tree node has %d blob keys, expected 1", len(node.DataBlobKeys))
+	}
+	h := node.DataBlobKeys[0].Hash
+	f.mu.Lock()
+	for j, c := range f.subtrees {
+		if c.h == h {
+			copy(f.subtrees[1:j+1], f.subtrees[:j])
+			f.subtrees[0] = c
+			f.mu.Unlock()
+			return c.t, nil
+		}
+	}
+	f.mu.Unlock()
+
+	t, err := f.Tree(ctx, h, node.DataCompressionType)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.subtrees) < subtreeCacheSize {
+		f.subtrees = append(f.subtrees, cachedTree{})
+	}
+	copy(f.subtrees[1:], f.subtrees)
+	f.subtrees[0] = cachedTree{h: h, t: t}
+	return t, nil
+}
+
+// LoadDirMetadata fills in the metadata of a directory node passed to a
+// WalkFunc, like its mode and modification time, which Arq stores in the
+// directory's subtree rather than in the node. It does nothing for other
+// nodes. The node is modified, so it must not be one inside a tree returned
+// by Tree which others are using.
+func (f *Folder) LoadDirMetadata(ctx context.Context, node *ArqNode) error {
+	if !node.IsTree {
+		return nil
+	}
+	t, err := f.subtree(ctx, node)
+	if err != nil {
+		return err
+	}
+	t.copyMetadata(node)
+	return nil
+}
+
+// copyMetadata fills in the metadata of a tree node, which Arq only stores in
+// the tree itself.
+func (t *ArqTree) copyMetadata(n *ArqNode) {
+	n.XattrsCompressionType = t.XattrsCompressionType
+	n.AclCompressionType = t.AclCompressionType
+	n.XattrsBlobKey = t.XattrsBlobKey
+	n.XattrsSize = t.XattrsSize
+	n.AclBlobKey = t.AclBlobKey
+	n.Uid = t.Uid
+	n.Gid = t.Gid
+	n.Mode = t.Mode
+	n.Mtime = t.Mtime
+	n.Flags = t.Flags
+	n.FinderFlags = t.FinderFlags
+	n.ExtendedFinderFlags = t.ExtendedFinderFlags
+	n.StDev = t.StDev
+	n.StIno = t.StIno
+	n.StNlink = t.StNlink
+	n.StRdev = t.StRdev
+	n.Ctime = t.Ctime
+	n.CreateTime = time.Unix(t.CreateTimeSec, t.CreateTimeNsec)
+	n.StBlocks = t.StBlocks
+	n.StBlkSize = t.StBlkSize
+}
@@ -0,0 +1,195 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	master, err := f.FindMaster(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	commit, err := f.Commit(ctx, master)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	t.Run("All", func(t *testing.T) {
+		var paths []string
+		sizes := make(map[string]uint64)
+		err := f.Walk(ctx, commit, func(p string, node *arq.ArqNode, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, p)
+			if !node.IsTree {
+				sizes[p] = node.DataSize
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2600-0.txt", "one.txt", "somedir", "somedir/two.txt"}, paths)
+		assert.Equal(t, uint64(3359584), sizes["2600-0.txt"])
+		assert.Equal(t, uint64(26), sizes["one.txt"])
+		assert.Equal(t, uint64(105), sizes["somedir/two.txt"])
+	})
+
+	t.Run("SkipDir", func(t *testing.T) {
+		var paths []string
+		err := f.Walk(ctx, commit, func(p string, node *arq.ArqNode, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, p)
+			if node.IsTree {
+				return arq.SkipDir
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2600-0.txt", "one.txt", "somedir"}, paths)
+	})
+
+	t.Run("LoadDirMetadata", func(t *testing.T) {
+		var dir *arq.ArqNode
+		err := f.Walk(ctx, commit, func(p string, node *arq.ArqNode, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != "somedir" {
+				return nil
+			}
+			// The subtree, holding the metadata, hasn't been loaded yet.
+			assert.Zero(t, node.Mode)
+			if err := f.LoadDirMetadata(ctx, node); err != nil {
+				return err
+			}
+			dir = node
+			return nil
+		})
+		if !assert.Nil(t, err) || !assert.NotNil(t, dir) {
+			return
+		}
+		assert.True(t, dir.FileMode().IsDir())
+		assert.Equal(t, int32(501), dir.Uid)
+		assert.False(t, dir.Mtime.IsZero())
+	})
+}
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	master, err := f.FindMaster(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	commit, err := f.Commit(ctx, master)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	node, err := f.Lookup(ctx, commit, "somedir/two.txt")
+	if assert.Nil(t, err) {
+		assert.False(t, node.IsTree)
+		assert.Equal(t, uint64(105), node.DataSize)
+	}
+	node, err = f.Lookup(ctx, commit, "/somedir/")
+	if assert.Nil(t, err) {
+		assert.True(t, node.IsTree)
+		assert.True(t, node.FileMode().IsDir())
+	}
+	_, err = f.Lookup(ctx, commit, "one.txt/two.txt")
+	assert.ErrorIs(t, err, arq.ErrNotFound)
+	_, err = f.Lookup(ctx, commit, "nope")
+	assert.ErrorIs(t, err, arq.ErrNotFound)
+}
+
+func TestWalkSharedSubtrees(t *testing.T) {
+	ctx := context.Background()
+	dir := copyT1(t)
+	f := openT1FolderAt(t, dir)
+	if f == nil {
+		return
+	}
+	commits := loadT1Commits(t, f, t1Commit3)
+	if commits == nil {
+		return
+	}
+	root, err := f.Tree(ctx, commits[0].TreeBlobKey.Hash, commits[0].TreeCompressionType)
+	if !assert.Nil(t, err) {
+		return
+	}
+	// Nest somedir inside a new directory, so that its node is inside a
+	// subtree the folder caches, rather than in the root tree which each walk
+	// loads itself.
+	const (
+		nestedHash = "00000000000000000000000000000000000000aa"
+		rootHash   = "00000000000000000000000000000000000000bb"
+	)
+	var somedir arq.ArqTreeNode
+	for _, n := range root.Nodes {
+		if n.FileName == "somedir" {
+			somedir = n
+		}
+	}
+	nested := *root
+	nested.Nodes = []arq.ArqTreeNode{somedir}
+	var plain bytes.Buffer
+	if !assert.Nil(t, arq.EncodeArq(&plain, &nested)) {
+		return
+	}
+	writeT1Object(t, dir, nestedHash, lz4Frame(t, plain.Bytes()))
+	a := somedir
+	a.FileName = "a"
+	a.Node.DataBlobKeys = []arq.ArqBlobKey{somedir.Node.DataBlobKeys[0]}
+	a.Node.DataBlobKeys[0].Hash, _ = arq.DecodeShaHashString(nestedHash)
+	a.Node.DataCompressionType = arq.Lz4Compression
+	root.Nodes = append([]arq.ArqTreeNode{a}, root.Nodes...)
+	plain.Reset()
+	if !assert.Nil(t, arq.EncodeArq(&plain, root)) {
+		return
+	}
+	writeT1Object(t, dir, rootHash, lz4Frame(t, plain.Bytes()))
+	commit := *commits[0]
+	commit.TreeBlobKey.Hash, _ = arq.DecodeShaHashString(rootHash)
+	commit.TreeCompressionType = arq.Lz4Compression
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dirs []string
+			err := f.Walk(ctx, &commit, func(p string, node *arq.ArqNode, err error) error {
+				if err != nil || !node.IsTree {
+					return err
+				}
+				// Other walks mustn't have filled in the node.
+				assert.Zero(t, node.Mode, p)
+				if err := f.LoadDirMetadata(ctx, node); err != nil {
+					return err
+				}
+				assert.True(t, node.FileMode().IsDir(), p)
+				dirs = append(dirs, p)
+				return nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"a", "a/somedir", "somedir"}, dirs)
+		}()
+	}
+	wg.Wait()
+}
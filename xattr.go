@@ -0,0 +1,123 @@
+package arq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var xattrSetHeader = []byte("XAttrSetV002")
+
+// Refuse to allocate more attributes, or a larger value, than this. macOS
+// limits most attributes to a few KiB, but resource forks can be large.
+const (
+	maxXAttrs     = 1 << 16
+	maxXAttrValue = 1 << 28
+)
+
+// XAttrSet is a node's extended attributes, like com.apple.quarantine,
+// keyed by name.
+type XAttrSet map[string][]byte
+
+// Names returns the names of the attributes in order.
+func (s XAttrSet) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *XAttrSet) UnmarshalArq(r io.Reader) error {
+	var header [12]byte
+	if err := DecodeArq(r, &header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:], xattrSetHeader) {
+		return fmt.Errorf("header '%s' is incorrect for XAttrSet", header)
+	}
+	var count uint64
+	if err := DecodeArq(r, &count); err != nil {
+		return err
+	}
+	if count > maxXAttrs {
+		return fmt.Errorf("XAttrSet has %d attributes: %w", count, ErrTooLong)
+	}
+	*s = make(XAttrSet, count)
+	for i := uint64(0); i < count; i++ {
+		var name string
+		var length uint64
+		if err := decodeFields(r, &name, &length); err != nil {
+			return err
+		}
+		if length > maxXAttrValue {
+			return fmt.Errorf("xattr '%s' is %d bytes: %w", name, length, ErrTooLong)
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return fmt.Errorf("xattr '%s': %w", name, err)
+		}
+		(*s)[name] = value
+	}
+	return nil
+}
+
+// MarshalArq encodes the set in the XAttrSetV002 format, ordered by name.
+func (s XAttrSet) MarshalArq(w io.Writer) (int, error) {
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(xattrSetHeader); err != nil {
+		return int(cw.n), err
+	}
+	if err := EncodeArq(cw, uint64(len(s))); err != nil {
+		return int(cw.n), err
+	}
+	for _, name := range s.Names() {
+		if err := encodeFields(cw, name, uint64(len(s[name]))); err != nil {
+			return int(cw.n), err
+		}
+		if _, err := cw.Write(s[name]); err != nil {
+			return int(cw.n), err
+		}
+	}
+	return int(cw.n), nil
+}
+
+// XAttrs returns the extended attributes of node, or nil if it has none.
+func (f *Folder) XAttrs(ctx context.Context, node *ArqNode) (XAttrSet, error) {
+	if node.XattrsBlobKey.Hash == (ShaHash{}) {
+		return nil, nil
+	}
+	rc, err := f.computer.Objects().Get(ctx, node.XattrsBlobKey.Hash, node.XattrsCompressionType)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var s XAttrSet
+	if err := DecodeArq(bufio.NewReader(rc), &s); err != nil {
+		return nil, fmt.Errorf("xattrs %s: %w", node.XattrsBlobKey.Hash, err)
+	}
+	return s, nil
+}
+
+// ACL returns the access control list of node in the text form produced by
+// acl_to_text(3), or an empty string if it has none.
+func (f *Folder) ACL(ctx context.Context, node *ArqNode) (string, error) {
+	if node.AclBlobKey.Hash == (ShaHash{}) {
+		return "", nil
+	}
+	rc, err := f.computer.Objects().Get(ctx, node.AclBlobKey.Hash, node.AclCompressionType)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, rc); err != nil {
+		return "", fmt.Errorf("acl %s: %w", node.AclBlobKey.Hash, err)
+	}
+	return strings.TrimRight(sb.String(), "\x00"), nil
+}
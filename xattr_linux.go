@@ -0,0 +1,33 @@
+package arq
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// The namespaces of Linux extended attributes.
+var linuxXAttrNamespaces = []string{"user.", "security.", "trusted.", "system."}
+
+// linuxXAttrName returns the name to store an attribute under on Linux.
+// Attributes from macOS, like com.apple.quarantine, have no namespace, and
+// are put in "user.", the only one unprivileged processes may set. Those
+// backed up from Linux keep theirs.
+func linuxXAttrName(name string) string {
+	for _, ns := range linuxXAttrNamespaces {
+		if strings.HasPrefix(name, ns) {
+			return name
+		}
+	}
+	return "user." + name
+}
+
+// setXAttr sets the extended attribute name of the file or directory at
+// target. Filesystems without extended attributes are silently skipped.
+func setXAttr(target, name string, value []byte) error {
+	err := syscall.Setxattr(target, linuxXAttrName(name), value, 0)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil
+	}
+	return err
+}
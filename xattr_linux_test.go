@@ -0,0 +1,58 @@
+package arq_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreXAttrs(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	master, err := f.FindMaster(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	commit, err := f.Commit(ctx, master)
+	if !assert.Nil(t, err) {
+		return
+	}
+	tdir := t.TempDir()
+	if !assert.Nil(t, arq.Restore(ctx, f, commit, tdir, arq.RestoreOptions{XAttrs: true})) {
+		return
+	}
+	buf := make([]byte, 1024)
+	n, err := syscall.Getxattr(filepath.Join(tdir, "2600-0.txt"), "user.com.apple.quarantine", buf)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("the temporary directory doesn't support extended attributes")
+	}
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "0081;60b0c1bf;Chrome;F3DEB02C-2167-41F7-8454-22874242C822", string(buf[:n]))
+
+	_, err = syscall.Getxattr(filepath.Join(tdir, "one.txt"), "user.com.apple.quarantine", buf)
+	assert.ErrorIs(t, err, syscall.ENODATA)
+}
+
+func TestLinuxXAttrName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"com.apple.quarantine":    "user.com.apple.quarantine",
+		"com.apple.FinderInfo":    "user.com.apple.FinderInfo",
+		"securityish":             "user.securityish",
+		"user.comment":            "user.comment",
+		"security.selinux":        "security.selinux",
+		"trusted.overlay.opaque":  "trusted.overlay.opaque",
+		"system.posix_acl_access": "system.posix_acl_access",
+	} {
+		assert.Equal(t, expected, arq.LinuxXAttrName(name), name)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package arq
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setXAttr(target, name string, value []byte) error {
+	return fmt.Errorf("restoring extended attributes isn't supported on %s", runtime.GOOS)
+}
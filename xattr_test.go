@@ -0,0 +1,76 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sholiday/arq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXAttrs(t *testing.T) {
+	ctx := context.Background()
+	f := openT1Folder(t)
+	if f == nil {
+		return
+	}
+	master, err := f.FindMaster(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	commit, err := f.Commit(ctx, master)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	node, err := f.Lookup(ctx, commit, "2600-0.txt")
+	if !assert.Nil(t, err) {
+		return
+	}
+	attrs, err := f.XAttrs(ctx, node)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"com.apple.metadata:kMDItemWhereFroms", "com.apple.quarantine"}, attrs.Names())
+	assert.Equal(t, "0081;60b0c1bf;Chrome;F3DEB02C-2167-41F7-8454-22874242C822", string(attrs["com.apple.quarantine"]))
+	assert.True(t, bytes.HasPrefix(attrs["com.apple.metadata:kMDItemWhereFroms"], []byte("bplist00")))
+
+	acl, err := f.ACL(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, "", acl)
+
+	t.Run("None", func(t *testing.T) {
+		node, err := f.Lookup(ctx, commit, "one.txt")
+		if !assert.Nil(t, err) {
+			return
+		}
+		attrs, err := f.XAttrs(ctx, node)
+		assert.Nil(t, err)
+		assert.Nil(t, attrs)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := attrs.MarshalArq(&buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, buf.Len(), n)
+		var actual arq.XAttrSet
+		if !assert.Nil(t, arq.DecodeArq(&buf, &actual)) {
+			return
+		}
+		assert.Equal(t, attrs, actual)
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		var actual arq.XAttrSet
+		assert.NotNil(t, arq.DecodeArq(bytes.NewReader([]byte("XAttrSetV001\x00\x00\x00\x00\x00\x00\x00\x00")), &actual))
+		// One attribute, claiming a value far larger than the data.
+		data := []byte("XAttrSetV002\x00\x00\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x01a\x00\x00\x00\x00\x00\x00\x00\x08ab")
+		assert.NotNil(t, arq.DecodeArq(bytes.NewReader(data), &actual))
+		data = []byte("XAttrSetV002\xff\xff\xff\xff\xff\xff\xff\xff")
+		assert.ErrorIs(t, arq.DecodeArq(bytes.NewReader(data), &actual), arq.ErrTooLong)
+	})
+}